@@ -18,6 +18,7 @@ package v1alpha1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -32,6 +33,196 @@ type AddonRepositorySpec struct {
 	Reference string `json:"reference,omitempty"`
 }
 
+// SnapRefreshSpec configures automatic refreshes of the MicroK8s snap.
+type SnapRefreshSpec struct {
+	// Hold postpones automatic refreshes of the MicroK8s snap until the specified time.
+	// It must be an RFC3339 timestamp, or "forever". Other snaps on the node are not affected.
+	// Requires snapd 2.58 or newer.
+	Hold string `json:"hold,omitempty"`
+
+	// Timer restricts automatic refreshes to the specified windows, e.g. "fri,23:00-01:00".
+	// Snapd does not support per-snap refresh timers, so this sets the system-wide refresh.timer
+	// option and applies to all snaps installed on the node.
+	// See https://snapcraft.io/docs/keeping-snaps-up-to-date for the format.
+	Timer string `json:"timer,omitempty"`
+}
+
+const (
+	// DisruptionStrategyInPlace restarts services in place.
+	DisruptionStrategyInPlace = "InPlace"
+	// DisruptionStrategyDrain cordons and drains the node before restarting services.
+	DisruptionStrategyDrain = "Drain"
+)
+
+// DisruptionPolicySpec configures how disruptive service restarts are performed on the node.
+type DisruptionPolicySpec struct {
+	// Strategy is the strategy to use for service restarts. With "Drain", the node is cordoned and drained
+	// before restarting, and uncordoned once kubelet reports Ready again. Defaults to "InPlace".
+	//+kubebuilder:validation:Enum=InPlace;Drain
+	Strategy string `json:"strategy,omitempty"`
+
+	// DrainTimeout is the maximum time to wait for pods to be evicted. Defaults to 5 minutes.
+	DrainTimeout *metav1.Duration `json:"drainTimeout,omitempty"`
+
+	// ReadyTimeout is the maximum time to wait for the node to become Ready after a restart. Defaults to 5 minutes.
+	ReadyTimeout *metav1.Duration `json:"readyTimeout,omitempty"`
+}
+
+// KeyReference refers to a key of a ConfigMap or Secret.
+type KeyReference struct {
+	// Namespace is the namespace of the object.
+	Namespace string `json:"namespace"`
+
+	// Name is the name of the object.
+	Name string `json:"name"`
+
+	// Key is the key in the object data.
+	Key string `json:"key"`
+}
+
+// AuditSpec configures audit logging for kube-apiserver.
+// See https://kubernetes.io/docs/tasks/debug/debug-cluster/audit/ for details.
+type AuditSpec struct {
+	// Policy refers to a ConfigMap key with the audit Policy (audit.k8s.io/v1).
+	Policy KeyReference `json:"policy"`
+
+	// LogPath is the path of the audit log on the node, or "-" for standard output.
+	// The log backend is disabled if empty.
+	LogPath string `json:"logPath,omitempty"`
+
+	// LogMaxAge is the maximum number of days to retain old audit log files.
+	LogMaxAge *int32 `json:"logMaxAge,omitempty"`
+
+	// LogMaxBackup is the maximum number of old audit log files to retain.
+	LogMaxBackup *int32 `json:"logMaxBackup,omitempty"`
+
+	// LogMaxSize is the maximum size in megabytes of the audit log file before it gets rotated.
+	LogMaxSize *int32 `json:"logMaxSize,omitempty"`
+
+	// WebhookConfig refers to a Secret key with a kubeconfig file for the audit webhook backend.
+	// The webhook backend is disabled if not set.
+	WebhookConfig *KeyReference `json:"webhookConfig,omitempty"`
+
+	// WebhookMode is the mode of the audit webhook backend.
+	//+kubebuilder:validation:Enum=batch;blocking;blocking-strict
+	WebhookMode string `json:"webhookMode,omitempty"`
+}
+
+// EncryptionSpec configures encryption at rest for kube-apiserver.
+// See https://kubernetes.io/docs/tasks/administer-cluster/encrypt-data/ for details.
+type EncryptionSpec struct {
+	// SecretNamespace and SecretName refer to a Secret with the encryption keys. Each key of the Secret is a
+	// 32 byte encryption key, and the name of the key is used as the key name in the EncryptionConfiguration.
+	SecretNamespace string `json:"secretNamespace"`
+	SecretName      string `json:"secretName"`
+
+	// ActiveKey is the name of the key used to encrypt data. Changing it rotates the encryption key: the new key
+	// is added on all control plane nodes, then it is made the primary key, all secrets are rewritten, and finally
+	// the old key is removed. Control plane nodes apply the keys of each phase one at a time, since kube-apiserver
+	// is restarted. Changes during a rotation are applied after the rotation is complete.
+	ActiveKey string `json:"activeKey"`
+
+	// Provider is the encryption provider. Defaults to "aescbc".
+	//+kubebuilder:validation:Enum=aescbc;aesgcm;secretbox
+	Provider string `json:"provider,omitempty"`
+
+	// Resources are the resources to encrypt, as "resource.group". Defaults to "secrets". All objects of these
+	// resources are rewritten during a key rotation, so the node agent must be allowed to list and update them.
+	// The node agents are allowed to read and update secrets in all namespaces for this. Access to other resources
+	// must be granted to the service account of the node agents, otherwise key rotations are refused.
+	Resources []string `json:"resources,omitempty"`
+}
+
+// OIDCSpec configures OpenID Connect authentication for kube-apiserver.
+type OIDCSpec struct {
+	// IssuerURL is the URL of the OpenID issuer. Only the https scheme is accepted.
+	IssuerURL string `json:"issuerURL"`
+
+	// ClientID is the client ID for the OpenID Connect client.
+	ClientID string `json:"clientID"`
+
+	// UsernameClaim is the JWT claim to use as the user name. Defaults to "sub".
+	UsernameClaim string `json:"usernameClaim,omitempty"`
+
+	// UsernamePrefix is prepended to user names to prevent clashes with existing names.
+	UsernamePrefix string `json:"usernamePrefix,omitempty"`
+
+	// GroupsClaim is the JWT claim to use as the user's groups.
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+
+	// GroupsPrefix is prepended to group names to prevent clashes with existing names.
+	GroupsPrefix string `json:"groupsPrefix,omitempty"`
+
+	// RequiredClaims are claims that must be present in the ID token with a matching value.
+	RequiredClaims map[string]string `json:"requiredClaims,omitempty"`
+
+	// CA refers to a ConfigMap key with the CA certificate that signed the identity provider's certificate.
+	// The host's root CAs are used if not set.
+	CA *KeyReference `json:"ca,omitempty"`
+}
+
+// AuthenticationSpec configures authentication and authorization for kube-apiserver.
+type AuthenticationSpec struct {
+	// OIDC configures OpenID Connect authentication.
+	OIDC *OIDCSpec `json:"oidc,omitempty"`
+
+	// WebhookConfig refers to a Secret key with a kubeconfig file for webhook token authentication.
+	WebhookConfig *KeyReference `json:"webhookConfig,omitempty"`
+
+	// WebhookCacheTTL is the duration to cache responses from the authentication webhook.
+	WebhookCacheTTL *metav1.Duration `json:"webhookCacheTTL,omitempty"`
+
+	// AuthorizationWebhookConfig refers to a Secret key with a kubeconfig file for webhook authorization.
+	// If set, the Webhook authorization mode is enabled.
+	AuthorizationWebhookConfig *KeyReference `json:"authorizationWebhookConfig,omitempty"`
+}
+
+// AdmissionSpec configures admission plugins for kube-apiserver.
+type AdmissionSpec struct {
+	// EnablePlugins are admission plugins to enable, in addition to the default ones.
+	// The lists of the default and node configurations are merged, with the node configuration taking precedence.
+	EnablePlugins []string `json:"enablePlugins,omitempty"`
+
+	// DisablePlugins are admission plugins to disable, even if they are enabled by default.
+	DisablePlugins []string `json:"disablePlugins,omitempty"`
+
+	// Config is an AdmissionConfiguration (apiserver.config.k8s.io/v1) in YAML, e.g. to configure the defaults
+	// and exemptions of PodSecurity, or EventRateLimit. The node configuration replaces the default one.
+	// If set, it replaces the admission configuration file of MicroK8s, so it must also configure the enabled
+	// plugins that need a configuration, e.g. EventRateLimit.
+	Config string `json:"config,omitempty"`
+}
+
+// ImageTarballSpec is an image archive to import on the nodes.
+type ImageTarballSpec struct {
+	// HostPath is the path of the archive on the node. It must be under /var/snap/microk8s, which is the only
+	// host directory available to the node agent. One of HostPath or URL must be set.
+	HostPath string `json:"hostPath,omitempty"`
+
+	// URL is an http or https URL to download the archive from.
+	URL string `json:"url,omitempty"`
+
+	// SHA256 is the expected SHA256 checksum of the archive.
+	//+kubebuilder:validation:Pattern=`^[a-f0-9]{64}$`
+	SHA256 string `json:"sha256"`
+}
+
+// ImagesSpec configures images that must be present on the nodes.
+type ImagesSpec struct {
+	// Pull is a list of image references to pull.
+	Pull []string `json:"pull,omitempty"`
+
+	// Tarballs is a list of image archives to import, e.g. for air-gapped nodes.
+	Tarballs []ImageTarballSpec `json:"tarballs,omitempty"`
+}
+
+const (
+	// DriftPolicyCorrect re-applies the desired state when managed files on the node change.
+	DriftPolicyCorrect = "Correct"
+	// DriftPolicyObserve does not change the node, and only reports managed files that differ from the desired state.
+	DriftPolicyObserve = "Observe"
+)
+
 // ConfigurationSpec defines the desired state of Configuration
 type ConfigurationSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
@@ -60,8 +251,56 @@ type ConfigurationSpec struct {
 	// ExtraKubeletArgs are extra arguments to pass to kubelet.
 	ExtraKubeletArgs map[string]*string `json:"extraKubeletArgs,omitempty"`
 
+	// KubeletConfig is a partial KubeletConfiguration (kubelet.config.k8s.io/v1beta1) object, e.g. with
+	// evictionHard, systemReserved, kubeReserved, imageGCHighThresholdPercent or shutdownGracePeriod.
+	// It is written to a config file that is passed to kubelet with --config. Note that kubelet arguments take
+	// precedence over the config file. The default and node configurations are merged field by field.
+	//+kubebuilder:pruning:PreserveUnknownFields
+	KubeletConfig *runtime.RawExtension `json:"kubeletConfig,omitempty"`
+
 	// ExtraAPIServerArgs are extra arguments to pass to kube-apiserver.
 	ExtraAPIServerArgs map[string]*string `json:"extraKubeAPIServerArgs,omitempty"`
+
+	// Encryption configures encryption at rest for kube-apiserver on control plane nodes.
+	// It is only used from the default Configuration, since the keys must be the same on all nodes.
+	Encryption *EncryptionSpec `json:"encryption,omitempty"`
+
+	// Authentication configures authentication and authorization for kube-apiserver on control plane nodes.
+	// The node configuration replaces the default one. If not set, the authentication arguments and the Webhook
+	// authorization mode are removed from kube-apiserver, unless they are set in ExtraAPIServerArgs.
+	Authentication *AuthenticationSpec `json:"authentication,omitempty"`
+
+	// Admission configures admission plugins for kube-apiserver on control plane nodes. It is applied on top of the
+	// admission arguments of MicroK8s, which are restored if it is removed.
+	// Arguments that are also set in ExtraAPIServerArgs take precedence.
+	Admission *AdmissionSpec `json:"admission,omitempty"`
+
+	// Audit configures audit logging for kube-apiserver. The node configuration replaces the default one.
+	// If not set, the audit arguments are removed from kube-apiserver, unless they are set in ExtraAPIServerArgs.
+	Audit *AuditSpec `json:"audit,omitempty"`
+
+	// FeatureGates are Kubernetes feature gates to enable or disable on kubelet, kube-apiserver,
+	// kube-controller-manager, kube-scheduler and kube-proxy.
+	// For kubelet, they are set in the KubeletConfig if one is specified, or with --feature-gates otherwise.
+	// Feature gates set explicitly with --feature-gates in the extra arguments or in the KubeletConfig take
+	// precedence. The default and node configurations are merged key by key.
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+
+	// Images configures images that must be present on the nodes. The lists of the default and node configurations
+	// are merged.
+	Images *ImagesSpec `json:"images,omitempty"`
+
+	// SnapRefresh configures automatic refreshes of the MicroK8s snap.
+	SnapRefresh *SnapRefreshSpec `json:"snapRefresh,omitempty"`
+
+	// DisruptionPolicy configures how disruptive service restarts are performed.
+	DisruptionPolicy *DisruptionPolicySpec `json:"disruptionPolicy,omitempty"`
+
+	// DriftPolicy configures how changes to managed files that were not made by the operator are handled.
+	// With "Correct", the desired state is re-applied. With "Observe", the node is not changed and files that
+	// differ from the desired state are reported in the node status. Defaults to "Correct".
+	//+kubebuilder:validation:Enum=Correct;Observe
+	DriftPolicy string `json:"driftPolicy,omitempty"`
 }
 
 type AddonRepositoryStatus struct {
@@ -71,13 +310,72 @@ type AddonRepositoryStatus struct {
 	Status string `json:"status"`
 }
 
+const (
+	// ConfigurationPhaseApplied is set when the configuration was applied successfully on a node.
+	ConfigurationPhaseApplied = "Applied"
+	// ConfigurationPhaseFailed is set when the configuration failed to apply on a node.
+	ConfigurationPhaseFailed = "Failed"
+	// ConfigurationPhaseDrifted is set when the node differs from the configuration and the drift policy is "Observe".
+	ConfigurationPhaseDrifted = "Drifted"
+)
+
+// ConfigurationNodeStatus is the status of the configuration on a single node
+type ConfigurationNodeStatus struct {
+	// Name is the name of the node.
+	Name string `json:"name"`
+
+	// Phase is the result of the last attempt to apply the configuration on the node.
+	Phase string `json:"phase"`
+
+	// Message is a human readable message with details about failures.
+	Message string `json:"message,omitempty"`
+
+	// Drift is the list of managed files on the node that differ from the desired state.
+	// It is only set when the drift policy is "Observe".
+	Drift []string `json:"drift,omitempty"`
+
+	// LastTransitionTime is the last time the phase, message or drift changed.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+}
+
+const (
+	// EncryptionPhaseStable is set when no key rotation is in progress.
+	EncryptionPhaseStable = "Stable"
+	// EncryptionPhaseAddingKey is set while the new key is added as a secondary key on all nodes.
+	EncryptionPhaseAddingKey = "AddingKey"
+	// EncryptionPhaseSwitchingPrimary is set while the new key is made the primary key on all nodes.
+	EncryptionPhaseSwitchingPrimary = "SwitchingPrimary"
+	// EncryptionPhaseRewritingSecrets is set while all secrets are rewritten with the new key.
+	EncryptionPhaseRewritingSecrets = "RewritingSecrets"
+	// EncryptionPhaseRemovingKey is set while the old key is removed from all nodes.
+	EncryptionPhaseRemovingKey = "RemovingKey"
+)
+
+// EncryptionStatus is the status of encryption at rest across the control plane nodes.
+type EncryptionStatus struct {
+	// Phase is the phase of the key rotation.
+	Phase string `json:"phase,omitempty"`
+
+	// Keys are the names of the keys that control plane nodes must use, primary key first.
+	Keys []string `json:"keys,omitempty"`
+
+	// LastTransitionTime is the last time the phase changed.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
 // ConfigurationStatus defines the observed state of Configuration
 type ConfigurationStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
 	// AddonRepositories is the status of the addon repositories
-	AddonRepositories []AddonRepositoryStatus `json:"addonRepositories"`
+	AddonRepositories []AddonRepositoryStatus `json:"addonRepositories,omitempty"`
+
+	// Nodes is the status of the configuration on each node it applies to.
+	Nodes []ConfigurationNodeStatus `json:"nodes,omitempty"`
+
+	// Encryption is the status of encryption at rest. It is only set on the default Configuration.
+	Encryption *EncryptionStatus `json:"encryption,omitempty"`
 }
 
 //+kubebuilder:object:root=true
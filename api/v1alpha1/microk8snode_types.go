@@ -23,12 +23,131 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+const (
+	// ConditionVersionSkew is true when the node violates the Kubernetes version skew policy.
+	ConditionVersionSkew = "VersionSkew"
+
+	// ConditionDiskPressure is true when the disk usage of the MicroK8s directories crosses the threshold.
+	ConditionDiskPressure = "DiskPressure"
+
+	// ConditionStale is true when the node agent has not updated the node for longer than the stale timeout.
+	// Stale nodes are deleted after the delete timeout.
+	ConditionStale = "Stale"
+)
+
+// SnapRefreshStatus is the status of automatic snap refreshes on the node.
+type SnapRefreshStatus struct {
+	// Timer is the configured snapd refresh timer.
+	Timer string `json:"timer,omitempty"`
+
+	// Hold is the time until which automatic refreshes are held, if any.
+	Hold string `json:"hold,omitempty"`
+
+	// Next is the time of the next scheduled refresh.
+	Next string `json:"next,omitempty"`
+
+	// Last is the time of the last refresh.
+	Last string `json:"last,omitempty"`
+}
+
+// ServiceStatus is the status of a MicroK8s snap service.
+type ServiceStatus struct {
+	// Name is the name of the service, e.g. "daemon-kubelite".
+	Name string `json:"name"`
+
+	// Enabled is true if the service is started on boot.
+	Enabled bool `json:"enabled"`
+
+	// Active is true if the service is running.
+	Active bool `json:"active"`
+}
+
+// FileBackup is a backup of a managed file on the node.
+type FileBackup struct {
+	// File is the path of the managed file on the host.
+	File string `json:"file"`
+
+	// Path is the path of the backup on the host.
+	Path string `json:"path"`
+
+	// Time is the time the backup was taken.
+	Time metav1.Time `json:"time"`
+}
+
+// DiskUsage is the usage of the filesystem that holds a directory.
+type DiskUsage struct {
+	// Path is the directory on the node.
+	Path string `json:"path"`
+
+	// TotalBytes is the size of the filesystem.
+	TotalBytes int64 `json:"totalBytes"`
+
+	// AvailableBytes is the space available to unprivileged users.
+	AvailableBytes int64 `json:"availableBytes"`
+
+	// UsedPercent is the percentage of the filesystem that is used.
+	UsedPercent int32 `json:"usedPercent"`
+}
+
+// ImageUsage is the size of a container image on the node.
+type ImageUsage struct {
+	// Names are the tags or digests of the image.
+	Names []string `json:"names,omitempty"`
+
+	// SizeBytes is the size of the image.
+	SizeBytes int64 `json:"sizeBytes"`
+}
+
+// StorageStatus is the storage usage of MicroK8s on the node.
+type StorageStatus struct {
+	// SnapCommon is the disk usage of the SNAP_COMMON directory, where containerd stores images and containers.
+	SnapCommon *DiskUsage `json:"snapCommon,omitempty"`
+
+	// SnapData is the disk usage of the SNAP_DATA directory.
+	SnapData *DiskUsage `json:"snapData,omitempty"`
+
+	// ImageCount is the number of images in containerd.
+	ImageCount int32 `json:"imageCount"`
+
+	// ImagesBytes is the total size of the images in containerd.
+	ImagesBytes int64 `json:"imagesBytes"`
+
+	// LargestImages are the largest images in containerd, largest first.
+	LargestImages []ImageUsage `json:"largestImages,omitempty"`
+}
+
+const (
+	// ImagePhasePresent is set when the image is present on the node.
+	ImagePhasePresent = "Present"
+	// ImagePhaseFailed is set when the image could not be pulled or imported.
+	ImagePhaseFailed = "Failed"
+	// ImagePhasePending is set while the image is checked, pulled or imported for the first time.
+	ImagePhasePending = "Pending"
+)
+
+// ImageStatus is the status of an image that must be present on the node.
+type ImageStatus struct {
+	// Image is the image reference, or the source of the image archive.
+	Image string `json:"image"`
+
+	// Phase is the result of the last attempt to pull or import the image. Images are pulled and imported in the
+	// background, so the phase is Pending until the first attempt completes.
+	Phase string `json:"phase"`
+
+	// ID is the image ID for pulled images, or the archive checksum for imported ones.
+	ID string `json:"id,omitempty"`
+
+	// Message is a human readable message with details about failures.
+	Message string `json:"message,omitempty"`
+}
+
 // MicroK8sNodeStatus defines the observed state of MicroK8sNode
 type MicroK8sNodeStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// LastUpdate is the timestamp of the last update of this node.
+	// LastUpdate is the timestamp of the last change of the status of this node. The node agent renews a
+	// heartbeat Lease to show that it is alive.
 	LastUpdate metav1.Time `json:"lastUpdate"`
 
 	// Revision is the installed MicroK8s snap revision.
@@ -42,6 +161,45 @@ type MicroK8sNodeStatus struct {
 
 	// Confinement is the MicroK8s snap confinement level.
 	Confinement string `json:"confinement"`
+
+	// Refresh is the status of automatic snap refreshes on the node.
+	Refresh SnapRefreshStatus `json:"refresh,omitempty"`
+
+	// Services is the status of the MicroK8s snap services.
+	Services []ServiceStatus `json:"services,omitempty"`
+
+	// DatastoreRole is the role of the node in the dqlite cluster. It is one of Voter, StandBy or Spare,
+	// or Worker for worker-only nodes. It is empty if the role is unknown.
+	DatastoreRole string `json:"datastoreRole,omitempty"`
+
+	// Addons are the addons enabled on the node, as "repository/addon".
+	Addons []string `json:"addons,omitempty"`
+
+	// AppliedConfigHash is a hash of the configuration that was last applied successfully on the node.
+	AppliedConfigHash string `json:"appliedConfigHash,omitempty"`
+
+	// RolledBackConfigHash is a hash of the configuration that was rolled back on the node because a service was
+	// unhealthy after applying it. The configuration is not applied again until it changes.
+	RolledBackConfigHash string `json:"rolledBackConfigHash,omitempty"`
+
+	// ConfiguredAfter is how long after joining the cluster the node was first configured. It is only set if the
+	// node agent holds a readiness gate on new nodes.
+	ConfiguredAfter *metav1.Duration `json:"configuredAfter,omitempty"`
+
+	// Conditions are the latest observations of the node's state.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Storage is the storage usage of MicroK8s on the node.
+	Storage StorageStatus `json:"storage,omitempty"`
+
+	// Images is the status of the images configured to be present on the node.
+	Images []ImageStatus `json:"images,omitempty"`
+
+	// EncryptionKeys are the names of the encryption keys used by kube-apiserver on the node, primary key first.
+	EncryptionKeys []string `json:"encryptionKeys,omitempty"`
+
+	// Backups are the backups of managed files on the node, newest first for each file.
+	Backups []FileBackup `json:"backups,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -50,7 +208,10 @@ type MicroK8sNodeStatus struct {
 // +kubebuilder:printcolumn:name="Version",type="string",JSONPath=".status.version",description="Installed version"
 // +kubebuilder:printcolumn:name="Revision",type="string",JSONPath=".status.revision",description="Installed revision"
 // +kubebuilder:printcolumn:name="Channel",type="string",JSONPath=".status.channel",description="Tracking channel"
+// +kubebuilder:printcolumn:name="Role",type="string",JSONPath=".status.datastoreRole",description="Datastore role"
 // +kubebuilder:printcolumn:name="Confinement",type="string",JSONPath=".status.confinement",description="Snap confinement level"
+// +kubebuilder:printcolumn:name="Disk",type="integer",JSONPath=".status.storage.snapCommon.usedPercent",description="Disk usage percentage of SNAP_COMMON",priority=1
+// +kubebuilder:printcolumn:name="NextRefresh",type="string",JSONPath=".status.refresh.next",description="Next scheduled snap refresh",priority=1
 // +kubebuilder:printcolumn:name="LastUpdate",type="date",JSONPath=".status.lastUpdate",description="age"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="age"
 
@@ -0,0 +1,105 @@
+/*
+Copyright 2022 Angelos Kolaitis.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// NodeOperationRestartService restarts a MicroK8s snap service.
+	NodeOperationRestartService = "restart-service"
+	// NodeOperationRefreshCertificates refreshes the MicroK8s certificates.
+	NodeOperationRefreshCertificates = "refresh-certs"
+	// NodeOperationRefetchAddons fetches the latest version of the addon repositories.
+	NodeOperationRefetchAddons = "refetch-addons"
+	// NodeOperationReloadConfiguration re-applies the Configuration on the node.
+	NodeOperationReloadConfiguration = "reload-config"
+)
+
+// MicroK8sNodeOperationSpec defines the desired state of MicroK8sNodeOperation
+type MicroK8sNodeOperationSpec struct {
+	// Node is the name of the node to run the operation on.
+	Node string `json:"node"`
+
+	// Action is the operation to run.
+	//+kubebuilder:validation:Enum=restart-service;refresh-certs;refetch-addons;reload-config
+	Action string `json:"action"`
+
+	// Service is the MicroK8s snap service to restart for restart-service, e.g. "daemon-containerd".
+	Service string `json:"service,omitempty"`
+
+	// Repository is the addon repository to refetch for refetch-addons. All repositories are refetched if empty.
+	Repository string `json:"repository,omitempty"`
+}
+
+const (
+	// NodeOperationPhaseRunning is set while the operation is running on the node.
+	NodeOperationPhaseRunning = "Running"
+	// NodeOperationPhaseSucceeded is set when the operation completed successfully.
+	NodeOperationPhaseSucceeded = "Succeeded"
+	// NodeOperationPhaseFailed is set when the operation failed.
+	NodeOperationPhaseFailed = "Failed"
+)
+
+// MicroK8sNodeOperationStatus defines the observed state of MicroK8sNodeOperation
+type MicroK8sNodeOperationStatus struct {
+	// Phase is the phase of the operation. It is empty until the node agent picks up the operation.
+	Phase string `json:"phase,omitempty"`
+
+	// Message is a human readable message with details about failures.
+	Message string `json:"message,omitempty"`
+
+	// Output is the output of the operation, if any.
+	Output string `json:"output,omitempty"`
+
+	// StartTime is the time the operation was started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is the time the operation completed.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Node",type="string",JSONPath=".spec.node",description="Target node"
+// +kubebuilder:printcolumn:name="Action",type="string",JSONPath=".spec.action",description="Operation"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase",description="Operation phase"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="age"
+
+// MicroK8sNodeOperation is the Schema for the microk8snodeoperations API
+type MicroK8sNodeOperation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MicroK8sNodeOperationSpec   `json:"spec,omitempty"`
+	Status MicroK8sNodeOperationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MicroK8sNodeOperationList contains a list of MicroK8sNodeOperation
+type MicroK8sNodeOperationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MicroK8sNodeOperation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MicroK8sNodeOperation{}, &MicroK8sNodeOperationList{})
+}
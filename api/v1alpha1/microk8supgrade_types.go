@@ -0,0 +1,139 @@
+/*
+Copyright 2022 Angelos Kolaitis.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MicroK8sUpgradeSpec defines the desired state of MicroK8sUpgrade
+type MicroK8sUpgradeSpec struct {
+	// Channel is the snap channel to refresh MicroK8s to, e.g. "1.25/stable".
+	Channel string `json:"channel,omitempty"`
+
+	// Revision is the snap revision to refresh MicroK8s to. If set, it takes precedence over Channel.
+	Revision string `json:"revision,omitempty"`
+
+	// AllowUnknownVersion allows refreshing to a target whose Kubernetes version cannot be determined from the
+	// channel or the snap store, e.g. a revision that is not released to any channel. The version skew check is
+	// skipped for such targets, so this must only be set when the target is known to be compatible.
+	AllowUnknownVersion bool `json:"allowUnknownVersion,omitempty"`
+
+	// DrainTimeout is the maximum time to wait for pods to be evicted from a node before refreshing it.
+	// Defaults to 5 minutes.
+	DrainTimeout *metav1.Duration `json:"drainTimeout,omitempty"`
+
+	// ReadyTimeout is the maximum time to wait for a node to become Ready after refreshing it.
+	// Defaults to 10 minutes.
+	ReadyTimeout *metav1.Duration `json:"readyTimeout,omitempty"`
+}
+
+const (
+	// UpgradePhasePending is set when an upgrade or a node upgrade has not started yet.
+	UpgradePhasePending = "Pending"
+	// UpgradePhaseInProgress is set when an upgrade is in progress.
+	UpgradePhaseInProgress = "InProgress"
+	// UpgradePhaseDraining is set while a node is being cordoned and drained.
+	UpgradePhaseDraining = "Draining"
+	// UpgradePhaseRefreshing is set while the MicroK8s snap of a node is being refreshed.
+	UpgradePhaseRefreshing = "Refreshing"
+	// UpgradePhaseWaitingReady is set while waiting for a node to become Ready after refreshing.
+	UpgradePhaseWaitingReady = "WaitingReady"
+	// UpgradePhaseCompleted is set when an upgrade or a node upgrade has completed successfully.
+	UpgradePhaseCompleted = "Completed"
+	// UpgradePhaseFailed is set when an upgrade or a node upgrade has failed.
+	UpgradePhaseFailed = "Failed"
+)
+
+// MicroK8sUpgradeNodeStatus is the upgrade status of a single node
+type MicroK8sUpgradeNodeStatus struct {
+	// Name is the name of the node.
+	Name string `json:"name"`
+
+	// ControlPlane is true if the node runs the control plane services.
+	ControlPlane bool `json:"controlPlane,omitempty"`
+
+	// Phase is the upgrade phase of the node.
+	Phase string `json:"phase"`
+
+	// Message is a human readable message with details about the upgrade of the node.
+	Message string `json:"message,omitempty"`
+
+	// Version is the MicroK8s snap version installed on the node after the refresh.
+	Version string `json:"version,omitempty"`
+
+	// StartTime is the time the upgrade of the node was started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// RefreshChange is the ID of the snapd change that refreshes the MicroK8s snap of the node.
+	RefreshChange string `json:"refreshChange,omitempty"`
+
+	// RefreshStartTime is the time the MicroK8s snap refresh of the node was started.
+	RefreshStartTime *metav1.Time `json:"refreshStartTime,omitempty"`
+
+	// RefreshTime is the time the MicroK8s snap refresh of the node completed.
+	RefreshTime *metav1.Time `json:"refreshTime,omitempty"`
+
+	// CompletionTime is the time the upgrade of the node was completed.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// MicroK8sUpgradeStatus defines the observed state of MicroK8sUpgrade
+type MicroK8sUpgradeStatus struct {
+	// Phase is the overall phase of the upgrade.
+	Phase string `json:"phase,omitempty"`
+
+	// Message is a human readable message with details about the upgrade.
+	Message string `json:"message,omitempty"`
+
+	// CurrentNode is the node that is currently being upgraded.
+	CurrentNode string `json:"currentNode,omitempty"`
+
+	// Nodes is the upgrade status of each node, in the order they are upgraded.
+	Nodes []MicroK8sUpgradeNodeStatus `json:"nodes,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Channel",type="string",JSONPath=".spec.channel",description="Target channel"
+// +kubebuilder:printcolumn:name="Revision",type="string",JSONPath=".spec.revision",description="Target revision"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase",description="Upgrade phase"
+// +kubebuilder:printcolumn:name="Node",type="string",JSONPath=".status.currentNode",description="Node being upgraded"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="age"
+
+// MicroK8sUpgrade is the Schema for the microk8supgrades API
+type MicroK8sUpgrade struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MicroK8sUpgradeSpec   `json:"spec,omitempty"`
+	Status MicroK8sUpgradeStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MicroK8sUpgradeList contains a list of MicroK8sUpgrade
+type MicroK8sUpgradeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MicroK8sUpgrade `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MicroK8sUpgrade{}, &MicroK8sUpgradeList{})
+}
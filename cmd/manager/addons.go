@@ -0,0 +1,137 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/metadata"
+)
+
+// addonResources are the resources that "microk8s status" checks for enabled addons, with the kind they are
+// printed as by "kubectl get all,ingress".
+var addonResources = []struct {
+	kind string
+	gvr  schema.GroupVersionResource
+}{
+	{kind: "pod", gvr: schema.GroupVersionResource{Version: "v1", Resource: "pods"}},
+	{kind: "service", gvr: schema.GroupVersionResource{Version: "v1", Resource: "services"}},
+	{kind: "daemonset.apps", gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}},
+	{kind: "deployment.apps", gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}},
+	{kind: "replicaset.apps", gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}},
+	{kind: "statefulset.apps", gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}},
+	{kind: "job.batch", gvr: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}},
+	{kind: "cronjob.batch", gvr: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}},
+	{kind: "ingress.networking.k8s.io", gvr: schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}},
+}
+
+// listAddonResources returns the names of the addon resources in all namespaces, e.g. "deployment.apps/coredns".
+func listAddonResources(ctx context.Context, client metadata.Interface) ([]string, error) {
+	var names []string
+	for _, resource := range addonResources {
+		// serve from the apiserver cache, the list does not need to be up to date
+		list, err := client.Resource(resource.gvr).List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", resource.gvr.Resource, err)
+		}
+		for _, item := range list.Items {
+			names = append(names, fmt.Sprintf("%s/%s", resource.kind, item.Name))
+		}
+	}
+	return names, nil
+}
+
+// addonResourceCache caches the addon resources of the cluster, so that the cluster-wide lists are not repeated on
+// every node on every refresh.
+type addonResourceCache struct {
+	client metadata.Interface
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	names   []string
+	expires time.Time
+}
+
+// list returns the cached addon resources, listing them again if the cache has expired.
+func (c *addonResourceCache) list(ctx context.Context) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.names != nil && time.Now().Before(c.expires) {
+		return c.names, nil
+	}
+	names, err := listAddonResources(ctx, c.client)
+	if err != nil {
+		return nil, err
+	}
+	if names == nil {
+		names = []string{}
+	}
+	c.names, c.expires = names, time.Now().Add(c.ttl)
+	return names, nil
+}
+
+// refetchAddonRepositories fetches the latest commit of the addon repositories in addonsDir and checks it out,
+// like "microk8s addons repo update". If name is empty, all repositories are updated.
+func refetchAddonRepositories(ctx context.Context, addonsDir, name string) (string, error) {
+	names := []string{name}
+	if name == "" {
+		entries, err := os.ReadDir(addonsDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to list addon repositories: %w", err)
+		}
+		names = names[:0]
+		for _, entry := range entries {
+			if entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+
+	var output []string
+	var errs []error
+	for _, name := range names {
+		hash, err := refetchAddonRepository(ctx, filepath.Join(addonsDir, name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to update addon repository %s: %w", name, err))
+			continue
+		}
+		output = append(output, fmt.Sprintf("%s: %s", name, hash))
+	}
+	return strings.Join(output, "\n"), kerrors.NewAggregate(errs)
+}
+
+// refetchAddonRepository updates a single addon repository and returns the checked out commit.
+func refetchAddonRepository(ctx context.Context, dir string) (string, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := repo.FetchContext(ctx, &git.FetchOptions{RemoteName: "origin", Depth: 1, Force: true}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return "", fmt.Errorf("failed to fetch: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	remote, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true)
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote branch: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	if err := worktree.Reset(&git.ResetOptions{Commit: remote.Hash(), Mode: git.HardReset}); err != nil {
+		return "", fmt.Errorf("failed to checkout %s: %w", remote.Hash(), err)
+	}
+	return remote.Hash().String(), nil
+}
@@ -0,0 +1,38 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// importImage imports an image archive in the k8s.io namespace of containerd, using the ctr binary of the snap.
+func importImage(ctx context.Context, snap, socket, file string) error {
+	cmd := exec.CommandContext(ctx, filepath.Join(snap, "bin", "ctr"), "--address", socket, "--namespace", "k8s.io", "images", "import", file)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ctr images import failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// hostPath maps a path in the node agent under the mount of /var/snap/microk8s to the path on the host.
+// Other paths are returned unchanged.
+func hostPath(snapData, path string) string {
+	rel, err := filepath.Rel(filepath.Dir(snapData), path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.Join("/var/snap/microk8s", rel)
+}
+
+// localPath maps a path under /var/snap/microk8s on the host to the path it is mounted in the node agent.
+func localPath(snapData, hostPath string) (string, error) {
+	root := filepath.Dir(snapData)
+	rel, err := filepath.Rel("/var/snap/microk8s", filepath.Clean(hostPath))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("path %s is not under /var/snap/microk8s", hostPath)
+	}
+	return filepath.Join(root, rel), nil
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,18 +31,27 @@ import (
 	"go.uber.org/zap/zapcore"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
-	"github.com/snapcore/snapd/client"
 	snapdclient "github.com/snapcore/snapd/client"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/metadata"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
 	"github.com/neoaggelos/microk8s-operator/controllers/configuration"
 	"github.com/neoaggelos/microk8s-operator/controllers/microk8snode"
+	"github.com/neoaggelos/microk8s-operator/controllers/nodeoperation"
+	"github.com/neoaggelos/microk8s-operator/controllers/upgrade"
+	"github.com/neoaggelos/microk8s-operator/controllers/versionskew"
+	"github.com/neoaggelos/microk8s-operator/pkg/cri"
+	"github.com/neoaggelos/microk8s-operator/pkg/microk8s"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -57,38 +67,60 @@ func init() {
 	//+kubebuilder:scaffold:scheme
 }
 
-func restartService(ctx context.Context, snapClient *snapdclient.Client, service string) error {
-	changeID, err := snapClient.Restart([]string{service}, client.RestartOptions{Reload: false})
-	if err != nil {
-		return err
-	}
-
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-
-	for {
-		if change, err := snapClient.Change(changeID); err != nil {
-			return err
-		} else if change.Status == "Done" {
-			return nil
-		}
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("timed out waiting for restart")
-		case <-time.After(time.Second):
-		}
-	}
-}
-
 func Main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var healthCheckTimeout time.Duration
+	var resyncInterval time.Duration
+	var backupRetention int
+	var diskUsageThreshold int
+	var nodeStaleAfter time.Duration
+	var nodeDeleteAfter time.Duration
+	var nodeLeaseDuration time.Duration
+	var nodeLabels string
+	var nodeAnnotations string
+	var readinessGate string
+	var readinessGateWindow time.Duration
+	var addonResourcesCacheTTL time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&healthCheckTimeout, "health-check-timeout", 2*time.Minute,
+		"How long to wait for services to become healthy after a restart, before rolling back changes.")
+	flag.DurationVar(&resyncInterval, "resync-interval", 10*time.Minute,
+		"How often to re-apply the configuration on the node, even if it has not changed. Set to 0 to disable.")
+	flag.IntVar(&backupRetention, "backup-retention", 5,
+		"How many backups to keep for each managed file on the node. Set to 0 to disable backups.")
+	flag.IntVar(&diskUsageThreshold, "disk-usage-threshold", 85,
+		"Disk usage percentage of the MicroK8s directories above which the DiskPressure condition is raised. Set to 0 to disable.")
+	flag.DurationVar(&nodeStaleAfter, "node-stale-after", 10*time.Minute,
+		"How long after its last update a MicroK8sNode is marked as stale.")
+	flag.DurationVar(&nodeDeleteAfter, "node-delete-after", 24*time.Hour,
+		"How long after its last update a stale MicroK8sNode is deleted.")
+	flag.DurationVar(&nodeLeaseDuration, "node-lease-duration", 40*time.Second,
+		"Duration of the heartbeat lease of the node. The lease is renewed every quarter of the duration.")
+	flag.StringVar(&nodeLabels, "node-labels", "",
+		"Comma-separated MicroK8sNode status fields to set as microk8s.io/<field> labels on the Node. "+
+			"Supported fields are channel, revision, version, confinement, datastore-role and config-hash. "+
+			"Labels of supported fields that are not listed are removed.")
+	flag.StringVar(&nodeAnnotations, "node-annotations", "",
+		"Comma-separated MicroK8sNode status fields to set as microk8s.io/<field> annotations on the Node. "+
+			"Supports the same fields as --node-labels. Annotations of supported fields that are not listed are removed.")
+	flag.StringVar(&readinessGate, "readiness-gate", "",
+		"Keep new nodes from running workloads until the configuration is first applied. One of \"taint\" "+
+			"(microk8s.io/unconfigured NoSchedule taint) or \"condition\" (MicroK8sConfigured Node condition, which is "+
+			"informational only, since the scheduler ignores it). Disabled if empty. The taint is set when the node agent "+
+			"first runs on the node, so workloads can be scheduled on a new node before that, unless its kubelet registers "+
+			"with the taint. The node agent adds the taint to the kubelet --register-with-taints argument, which covers "+
+			"nodes that register again; for new hosts, add it to the kubelet arguments before joining.")
+	flag.DurationVar(&readinessGateWindow, "readiness-gate-window", 30*time.Minute,
+		"How long after joining the cluster a node that was never configured is gated by --readiness-gate. "+
+			"Older nodes, e.g. existing nodes when the gate is first enabled, are not gated. Set to 0 to gate all nodes.")
+	flag.DurationVar(&addonResourcesCacheTTL, "addon-resources-cache-ttl", 10*time.Minute,
+		"How long to cache the cluster resources used to detect enabled addons. Set to 0 to list them on every refresh.")
 	opts := zap.Options{
 		Development: true,
 		TimeEncoder: zapcore.ISO8601TimeEncoder,
@@ -116,7 +148,30 @@ func Main() {
 		os.Exit(1)
 	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		setupLog.Info("POD_NAMESPACE is not set. It must be set to the namespace of the operator")
+		os.Exit(1)
+	}
+
+	switch readinessGate {
+	case "", configuration.ReadinessGateTaint, configuration.ReadinessGateCondition:
+	default:
+		setupLog.Info("invalid --readiness-gate, must be one of \"taint\" or \"condition\"", "value", readinessGate)
+		os.Exit(1)
+	}
+
+	nodeLabelFields := splitList(nodeLabels)
+	nodeAnnotationFields := splitList(nodeAnnotations)
+	for _, fields := range [][]string{nodeLabelFields, nodeAnnotationFields} {
+		if err := microk8snode.ValidateMetadataFields(fields); err != nil {
+			setupLog.Error(err, "invalid node metadata fields")
+			os.Exit(1)
+		}
+	}
+
+	restConfig := ctrl.GetConfigOrDie()
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
@@ -140,44 +195,212 @@ func Main() {
 		os.Exit(1)
 	}
 
+	snapSocket := os.Getenv("SNAP_SOCKET")
+	if snapSocket == "" {
+		snapSocket = "/run/snapd.socket"
+	}
 	snapClient := snapdclient.New(&snapdclient.Config{
-		Socket: os.Getenv("SNAP_SOCKET"),
+		Socket: snapSocket,
 	})
+	snapInfo := func(ctx context.Context) (microk8snode.SnapInfo, error) {
+		r, err := snapClient.List([]string{"microk8s"}, nil)
+		if err != nil {
+			return microk8snode.SnapInfo{}, err
+		}
+		if len(r) == 0 {
+			return microk8snode.SnapInfo{}, fmt.Errorf("no microk8s snap found")
+		}
+		apps, err := snapClient.Apps([]string{"microk8s"}, snapdclient.AppOptions{Service: true})
+		if err != nil {
+			return microk8snode.SnapInfo{}, fmt.Errorf("failed to list snap services: %w", err)
+		}
+		var services []microk8sv1alpha1.ServiceStatus
+		for _, app := range apps {
+			if strings.HasPrefix(app.Name, "daemon-") {
+				services = append(services, microk8sv1alpha1.ServiceStatus{Name: app.Name, Enabled: app.Enabled, Active: app.Active})
+			}
+		}
+		role, err := microk8s.DatastoreRole(snapData)
+		if err != nil {
+			ctrl.LoggerFrom(ctx).Error(err, "failed to retrieve datastore role")
+		}
+		return microk8snode.SnapInfo{
+			Revision:      r[0].Revision.String(),
+			Channel:       r[0].Channel,
+			Version:       r[0].Version,
+			Confinement:   r[0].Confinement,
+			Services:      services,
+			DatastoreRole: role,
+		}, nil
+	}
+
+	clientset := kubernetes.NewForConfigOrDie(restConfig)
+	addonCache := &addonResourceCache{client: metadata.NewForConfigOrDie(restConfig), ttl: addonResourcesCacheTTL}
+	dynamicClient := dynamic.NewForConfigOrDie(restConfig)
+
+	containerdSocket := filepath.Join(snapCommon, "run", "containerd.sock")
+	criClient, err := cri.New(containerdSocket)
+	if err != nil {
+		setupLog.Error(err, "unable to create CRI client")
+		os.Exit(1)
+	}
+	var importImageArchive func(ctx context.Context, file string) error
+	if snap := os.Getenv("SNAP"); snap != "" {
+		importImageArchive = func(ctx context.Context, file string) error {
+			return importImage(ctx, snap, containerdSocket, file)
+		}
+	}
+
+	refreshCertificates := func(ctx context.Context) error {
+		if _, err := snapClient.SetConf("microk8s", map[string]interface{}{
+			"operator-configure-hook": time.Now().String(),
+		}); err != nil {
+			return fmt.Errorf("failed to change snap config: %w", err)
+		}
+		return nil
+	}
+	configurationRequests := make(chan event.GenericEvent, 1)
+
+	var backupDir string
+	if backupRetention > 0 {
+		backupDir = filepath.Join(snapCommon, "operator", "backups")
+	}
 
 	if err = (&configuration.Reconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Clientset: clientset,
+		Dynamic:   dynamicClient,
+		Recorder:  mgr.GetEventRecorderFor("microk8s-operator"),
 
 		Node: nodeName,
 
 		RestartContainerd: func(ctx context.Context) error {
 			return restartService(ctx, snapClient, "microk8s.daemon-containerd")
 		},
-		RestartKubelet: func(ctx context.Context) error {
+		RestartKubelite: func(ctx context.Context) error {
 			return restartService(ctx, snapClient, "microk8s.daemon-kubelite")
 		},
-		RestartKubeAPIServer: func(ctx context.Context) error {
-			return restartService(ctx, snapClient, "microk8s.daemon-kubelite")
+		RefreshCertificates: refreshCertificates,
+		Requests:            configurationRequests,
+		ReadinessGate:       readinessGate,
+		ReadinessGateWindow: readinessGateWindow,
+		ConfigureSnapRefresh: func(ctx context.Context, hold, timer string) (bool, error) {
+			return configureSnapRefresh(ctx, snapClient, snapSocket, hold, timer)
 		},
-		RefreshCertificates: func(ctx context.Context) error {
-			if _, err := snapClient.SetConf("microk8s", map[string]interface{}{
-				"operator-configure-hook": time.Now().String(),
-			}); err != nil {
-				return fmt.Errorf("failed to change snap config: %w", err)
+		CSRConfFile:            filepath.Join(snapData, "certs", "csr.conf.template"),
+		RegistryCertsDir:       filepath.Join(snapData, "args", "certs.d"),
+		ContainerdEnvFile:      filepath.Join(snapData, "args", "containerd-env"),
+		KubeletArgsFile:        filepath.Join(snapData, "args", "kubelet"),
+		KubeAPIServerArgsFile:  filepath.Join(snapData, "args", "kube-apiserver"),
+		KubeletConfigFile:      filepath.Join(snapData, "args", "kubelet-config.yaml"),
+		AuditPolicyFile:        filepath.Join(snapData, "args", "audit-policy.yaml"),
+		AuditWebhookConfigFile: filepath.Join(snapData, "args", "audit-webhook.kubeconfig"),
+		EncryptionConfigFile:   filepath.Join(snapData, "args", "encryption-config.yaml"),
+
+		KubeControllerManagerArgsFile: filepath.Join(snapData, "args", "kube-controller-manager"),
+		KubeSchedulerArgsFile:         filepath.Join(snapData, "args", "kube-scheduler"),
+		KubeProxyArgsFile:             filepath.Join(snapData, "args", "kube-proxy"),
+
+		OIDCCAFile:                      filepath.Join(snapData, "args", "oidc-ca.crt"),
+		AuthenticationWebhookConfigFile: filepath.Join(snapData, "args", "authentication-webhook.kubeconfig"),
+		AuthorizationWebhookConfigFile:  filepath.Join(snapData, "args", "authorization-webhook.kubeconfig"),
+		AdmissionConfigFile:             filepath.Join(snapData, "args", "admission-config.yaml"),
+		AdmissionStateFile:              filepath.Join(snapCommon, "operator", "admission-state.json"),
+		// kubelite expands environment variables in the arguments files
+		ArgumentPath: func(file string) string {
+			if rel, err := filepath.Rel(snapData, file); err == nil && !strings.HasPrefix(rel, "..") {
+				return filepath.Join("${SNAP_DATA}", rel)
 			}
-			return nil
+			return file
 		},
-		CSRConfFile:           filepath.Join(snapData, "certs", "csr.conf.template"),
-		RegistryCertsDir:      filepath.Join(snapData, "args", "certs.d"),
-		ContainerdEnvFile:     filepath.Join(snapData, "args", "containerd-env"),
-		KubeletArgsFile:       filepath.Join(snapData, "args", "kubelet"),
-		KubeAPIServerArgsFile: filepath.Join(snapData, "args", "kube-apiserver"),
+
+		BackupDir:       backupDir,
+		BackupRetention: backupRetention,
 
 		AddonsDir: filepath.Join(snapCommon, "addons"),
+
+		ImageID: func(ctx context.Context, image string) (string, error) {
+			img, err := criClient.ImageStatus(ctx, image)
+			if err != nil || img == nil {
+				return "", err
+			}
+			return img.ID, nil
+		},
+		PullImage:   criClient.PullImage,
+		ImportImage: importImageArchive,
+		ImagesDir:   filepath.Join(snapCommon, "operator", "images"),
+		LocalPath: func(hostPath string) (string, error) {
+			return localPath(snapData, hostPath)
+		},
+
+		KubeletHealthzURL:      "http://127.0.0.1:10248/healthz",
+		KubeAPIServerReadyzURL: "https://127.0.0.1:16443/readyz",
+		ContainerdSocket:       containerdSocket,
+		HealthCheckTimeout:     healthCheckTimeout,
+
+		ResyncInterval: resyncInterval,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Configuration")
 		os.Exit(1)
 	}
+	if err = (&upgrade.Reconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Clientset: clientset,
+
+		Node: nodeName,
+
+		RefreshSnap: func(ctx context.Context, channel, revision string) (string, error) {
+			return refreshSnap(snapClient, channel, revision)
+		},
+		RefreshDone: func(ctx context.Context, changeID string) (bool, error) {
+			return changeDone(snapClient, changeID)
+		},
+		ResolveVersion: func(ctx context.Context, channel, revision string) (string, error) {
+			return resolveSnapVersion(snapClient, channel, revision)
+		},
+		SnapInfo: snapInfo,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MicroK8sUpgrade")
+		os.Exit(1)
+	}
+	if err = (&versionskew.Reconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("microk8s-operator"),
+
+		Node: nodeName,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VersionSkew")
+		os.Exit(1)
+	}
+	if err = (&nodeoperation.Reconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("microk8s-operator"),
+
+		Node: nodeName,
+
+		RestartService: func(ctx context.Context, service string) error {
+			return restartService(ctx, snapClient, service)
+		},
+		RefreshCertificates: refreshCertificates,
+		RefetchAddons: func(ctx context.Context, repository string) (string, error) {
+			return refetchAddonRepositories(ctx, filepath.Join(snapCommon, "addons"), repository)
+		},
+		ReloadConfiguration: func(ctx context.Context) error {
+			select {
+			case configurationRequests <- event.GenericEvent{Object: &microk8sv1alpha1.Configuration{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MicroK8sNodeOperation")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -190,26 +413,76 @@ func Main() {
 	}
 
 	nodeController := &microk8snode.Controller{
-		Client:   mgr.GetClient(),
-		Interval: time.Minute,
-		Node:     nodeName,
-		SnapInfo: func(ctx context.Context) (microk8snode.SnapInfo, error) {
-			r, err := snapClient.List([]string{"microk8s"}, nil)
+		Client:    mgr.GetClient(),
+		Recorder:  mgr.GetEventRecorderFor("microk8s-operator"),
+		Interval:  time.Minute,
+		Node:      nodeName,
+		SnapInfo:  snapInfo,
+		BackupDir: backupDir,
+		HostPath: func(path string) string {
+			return hostPath(snapData, path)
+		},
+		SnapCommon: snapCommon,
+		SnapData:   snapData,
+		Images: func(ctx context.Context) ([]microk8snode.Image, error) {
+			images, err := criClient.ListImages(ctx)
 			if err != nil {
-				return microk8snode.SnapInfo{}, err
+				return nil, err
+			}
+			result := make([]microk8snode.Image, 0, len(images))
+			for _, image := range images {
+				names := image.RepoTags
+				if len(names) == 0 {
+					names = image.RepoDigests
+				}
+				result = append(result, microk8snode.Image{Names: names, Size: image.Size})
 			}
-			if len(r) == 0 {
-				return microk8snode.SnapInfo{}, fmt.Errorf("no microk8s snap found")
+			return result, nil
+		},
+		DiskUsageThreshold: int32(diskUsageThreshold),
+		Clientset:          clientset,
+		LeaseNamespace:     namespace,
+		LeaseDuration:      nodeLeaseDuration,
+		NodeLabels:         nodeLabelFields,
+		NodeAnnotations:    nodeAnnotationFields,
+		Addons: func(ctx context.Context) ([]string, error) {
+			expand := func(key string) string {
+				switch key {
+				case "SNAP_DATA":
+					return snapData
+				case "SNAP_COMMON":
+					return snapCommon
+				}
+				return os.Getenv(key)
+			}
+			return microk8s.EnabledAddons(ctx, filepath.Join(snapCommon, "addons"), expand, addonCache.list)
+		},
+		RefreshInfo: func(ctx context.Context) (microk8snode.RefreshInfo, error) {
+			sysInfo, err := snapClient.SysInfo()
+			if err != nil {
+				return microk8snode.RefreshInfo{}, err
 			}
-			return microk8snode.SnapInfo{
-				Revision:    r[0].Revision.String(),
-				Channel:     r[0].Channel,
-				Version:     r[0].Version,
-				Confinement: r[0].Confinement,
+			return microk8snode.RefreshInfo{
+				Timer: sysInfo.Refresh.Timer,
+				Hold:  sysInfo.Refresh.Hold,
+				Next:  sysInfo.Refresh.Next,
+				Last:  sysInfo.Refresh.Last,
 			}, nil
 		},
 	}
 
+	sweeper := &microk8snode.Sweeper{
+		Client:      mgr.GetClient(),
+		Recorder:    mgr.GetEventRecorderFor("microk8s-operator"),
+		Interval:    time.Minute,
+		Node:        nodeName,
+		StaleAfter:  nodeStaleAfter,
+		DeleteAfter: nodeDeleteAfter,
+
+		Clientset:      clientset,
+		LeaseNamespace: namespace,
+	}
+
 	ctx, cancel := context.WithCancel(ctrl.SetupSignalHandler())
 	defer cancel()
 	wg := sync.WaitGroup{}
@@ -224,6 +497,16 @@ func Main() {
 		wg.Done()
 	}()
 
+	wg.Add(1)
+	go func() {
+		setupLog.Info("starting stale node sweeper")
+		if err := sweeper.Run(ctx); err != nil {
+			setupLog.Error(err, "problem running stale node sweeper")
+			cancel()
+		}
+		wg.Done()
+	}()
+
 	wg.Add(1)
 	go func() {
 		setupLog.Info("starting manager")
@@ -236,3 +519,14 @@ func Main() {
 
 	wg.Wait()
 }
+
+// splitList splits a comma-separated list, ignoring empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
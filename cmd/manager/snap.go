@@ -0,0 +1,249 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/neoaggelos/microk8s-operator/pkg/metrics"
+	snapdclient "github.com/snapcore/snapd/client"
+)
+
+// waitForChange waits until a snapd change is complete.
+func waitForChange(ctx context.Context, snapClient *snapdclient.Client, changeID string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		if change, err := snapClient.Change(changeID); err != nil {
+			return err
+		} else if change.Status == "Done" {
+			return nil
+		} else if change.Ready {
+			return fmt.Errorf("change %s finished with status %q: %s", changeID, change.Status, change.Err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for change %s", changeID)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func restartService(ctx context.Context, snapClient *snapdclient.Client, service string) (err error) {
+	start := time.Now()
+	defer func() {
+		outcome := metrics.Outcome(err)
+		metrics.ServiceRestarts.WithLabelValues(service, outcome).Inc()
+		metrics.ServiceRestartDuration.WithLabelValues(service, outcome).Observe(time.Since(start).Seconds())
+	}()
+
+	changeID, err := snapClient.Restart([]string{service}, snapdclient.RestartOptions{Reload: false})
+	if err != nil {
+		return err
+	}
+	if err := waitForChange(ctx, snapClient, changeID, 10*time.Second); err != nil {
+		return fmt.Errorf("failed waiting for restart: %w", err)
+	}
+	return nil
+}
+
+// refreshSnap starts refreshing the MicroK8s snap to the specified channel or revision, and returns the ID
+// of the snapd change. The change ID is empty if the snap is already up to date.
+func refreshSnap(snapClient *snapdclient.Client, channel, revision string) (string, error) {
+	changeID, err := snapClient.Refresh("microk8s", &snapdclient.SnapOptions{
+		Channel:  channel,
+		Revision: revision,
+	})
+	if err != nil {
+		var snapErr *snapdclient.Error
+		if errors.As(err, &snapErr) && snapErr.Kind == snapdclient.ErrorKindSnapNoUpdateAvailable {
+			return "", nil
+		}
+		return "", err
+	}
+	return changeID, nil
+}
+
+// resolveSnapVersion returns the version of the MicroK8s snap in the specified revision, or channel if revision
+// is empty, as published in the snap store. It returns an empty string if the revision is not released to any
+// channel, or the channel does not exist.
+func resolveSnapVersion(snapClient *snapdclient.Client, channel, revision string) (string, error) {
+	snap, _, err := snapClient.FindOne("microk8s")
+	if err != nil {
+		return "", err
+	}
+	if revision != "" {
+		for _, info := range snap.Channels {
+			if info.Revision.String() == revision {
+				return info.Version, nil
+			}
+		}
+		return "", nil
+	}
+	if !strings.Contains(channel, "/") {
+		channel += "/stable"
+	}
+	if info, ok := snap.Channels[channel]; ok {
+		return info.Version, nil
+	}
+	return "", nil
+}
+
+// changeDone returns true if a snapd change is complete, or an error if it did not complete successfully.
+func changeDone(snapClient *snapdclient.Client, changeID string) (bool, error) {
+	change, err := snapClient.Change(changeID)
+	if err != nil {
+		return false, err
+	}
+	if change.Ready && change.Status != "Done" {
+		return false, fmt.Errorf("change %s finished with status %q: %s", changeID, change.Status, change.Err)
+	}
+	return change.Ready, nil
+}
+
+// configureSnapRefresh holds automatic refreshes of the MicroK8s snap and sets the refresh.timer snapd
+// system option. Snapd does not support per-snap refresh timers, so the timer applies to all snaps of
+// the host. Empty values are left unchanged. It returns true if anything was changed.
+func configureSnapRefresh(ctx context.Context, snapClient *snapdclient.Client, socket, hold, timer string) (bool, error) {
+	var updated bool
+	if hold != "" {
+		changed, err := holdSnapRefresh(ctx, snapClient, socket, hold)
+		if err != nil {
+			return false, err
+		}
+		updated = changed
+	}
+	if timer == "" {
+		return updated, nil
+	}
+
+	conf, err := snapClient.Conf("system", []string{"refresh.timer"})
+	if err != nil {
+		var snapErr *snapdclient.Error
+		if !errors.As(err, &snapErr) || snapErr.Kind != snapdclient.ErrorKindConfigNoSuchOption {
+			return updated, fmt.Errorf("failed to get refresh.timer: %w", err)
+		}
+	}
+	if current, ok := conf["refresh.timer"]; ok && fmt.Sprint(current) == timer {
+		return updated, nil
+	}
+
+	changeID, err := snapClient.SetConf("system", map[string]interface{}{"refresh.timer": timer})
+	if err != nil {
+		return updated, fmt.Errorf("failed to change snap config: %w", err)
+	}
+	if err := waitForChange(ctx, snapClient, changeID, 30*time.Second); err != nil {
+		return updated, fmt.Errorf("failed waiting for snap config change: %w", err)
+	}
+	return true, nil
+}
+
+// holdSnapRefresh postpones automatic refreshes of the MicroK8s snap until hold, which is an RFC3339
+// timestamp or "forever". Unlike the refresh.hold system option, other snaps of the host are not
+// affected. This requires snapd 2.58 or newer. It returns true if the hold was changed.
+func holdSnapRefresh(ctx context.Context, snapClient *snapdclient.Client, socket, hold string) (bool, error) {
+	result, _, err := snapdRequest(ctx, socket, http.MethodGet, "/v2/snaps/microk8s", nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to get microk8s snap: %w", err)
+	}
+	var snap struct {
+		Hold string `json:"hold"`
+	}
+	if err := json.Unmarshal(result, &snap); err != nil {
+		return false, fmt.Errorf("failed to parse microk8s snap: %w", err)
+	}
+	if holdMatches(snap.Hold, hold, time.Now()) {
+		return false, nil
+	}
+
+	_, changeID, err := snapdRequest(ctx, socket, http.MethodPost, "/v2/snaps/microk8s", map[string]string{
+		"action":     "hold",
+		"hold-level": "auto-refresh",
+		"time":       hold,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to hold microk8s snap refreshes: %w", err)
+	}
+	if changeID != "" {
+		if err := waitForChange(ctx, snapClient, changeID, 30*time.Second); err != nil {
+			return false, fmt.Errorf("failed waiting for snap hold: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// holdMatches returns true if the current hold of a snap, as reported by snapd, matches hold.
+// Snapd reports "forever" holds as a time far in the future.
+func holdMatches(current, hold string, now time.Time) bool {
+	if current == "" {
+		return false
+	}
+	currentTime, err := time.Parse(time.RFC3339, current)
+	if err != nil {
+		return false
+	}
+	if hold == "forever" {
+		return currentTime.After(now.AddDate(100, 0, 0))
+	}
+	holdTime, err := time.Parse(time.RFC3339, hold)
+	if err != nil {
+		return false
+	}
+	return currentTime.Equal(holdTime)
+}
+
+// snapdRequest performs a request against the snapd REST API, for actions that are not supported by
+// the snapd client. It returns the result of the response, and the change ID for async responses.
+func snapdRequest(ctx context.Context, socket, method, path string, body interface{}) (json.RawMessage, string, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://localhost"+path, reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Type   string          `json:"type"`
+		Result json.RawMessage `json:"result"`
+		Change string          `json:"change"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	if response.Type == "error" {
+		var snapErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(response.Result, &snapErr)
+		return nil, "", fmt.Errorf("snapd returned error: %s", snapErr.Message)
+	}
+	return response.Result, response.Change, nil
+}
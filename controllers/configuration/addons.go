@@ -0,0 +1,69 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// reconcileAddonRepositories fetches the configured addon repositories.
+// Repositories that are already checked out from the same source are not fetched again.
+func (r *Reconciler) reconcileAddonRepositories(ctx context.Context, repos []microk8sv1alpha1.AddonRepositorySpec) error {
+	log := log.FromContext(ctx)
+	var errs []error
+	for _, repo := range repos {
+		log := log.WithValues("repository", repo.Name)
+		dir := filepath.Join(r.AddonsDir, repo.Name)
+		if isAddonRepositoryCheckedOut(dir, repo.Repository) {
+			log.Info("addon repository up to date")
+			continue
+		}
+		if r.observeOnly() {
+			r.drift.add(dir)
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Error(err, "Failed to cleanup dir")
+			errs = append(errs, fmt.Errorf("failed to clean up addon repository %s: %w", repo.Name, err))
+			continue
+		}
+
+		_, err := git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+			URL:   repo.Repository,
+			Depth: 1,
+		})
+		metrics.AddonRepositoryFetches.WithLabelValues(repo.Name, metrics.Outcome(err)).Inc()
+		if err != nil {
+			log.Error(err, "Failed to fetch repository")
+			r.events.Eventf(corev1.EventTypeWarning, reasonAddonRepoFailed, "Failed to fetch addon repository %s from %s: %v", repo.Name, repo.Repository, err)
+			errs = append(errs, fmt.Errorf("failed to fetch addon repository %s: %w", repo.Name, err))
+			continue
+		}
+
+		log.Info("Configured addon repository")
+		r.events.Eventf(corev1.EventTypeNormal, reasonAddonRepoFetched, "Fetched addon repository %s from %s", repo.Name, repo.Repository)
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// isAddonRepositoryCheckedOut returns true if dir is a git repository cloned from url.
+func isAddonRepositoryCheckedOut(dir string, url string) bool {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return false
+	}
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return false
+	}
+	urls := remote.Config().URLs
+	return len(urls) > 0 && urls[0] == url
+}
@@ -0,0 +1,231 @@
+package configuration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/hostfile"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+// mergeAdmission merges the admission configuration. Plugins enabled in overrides are removed from the disabled
+// plugins of base and vice versa.
+func mergeAdmission(base, overrides *microk8sv1alpha1.AdmissionSpec) *microk8sv1alpha1.AdmissionSpec {
+	if base == nil {
+		return overrides
+	}
+	if overrides == nil {
+		return base
+	}
+	enabled := make(map[string]struct{})
+	disabled := make(map[string]struct{})
+	for _, spec := range []*microk8sv1alpha1.AdmissionSpec{base, overrides} {
+		for _, plugin := range spec.EnablePlugins {
+			enabled[plugin] = struct{}{}
+			delete(disabled, plugin)
+		}
+		for _, plugin := range spec.DisablePlugins {
+			disabled[plugin] = struct{}{}
+			delete(enabled, plugin)
+		}
+	}
+
+	result := &microk8sv1alpha1.AdmissionSpec{Config: base.Config}
+	if o := overrides.Config; o != "" {
+		result.Config = o
+	}
+	for plugin := range enabled {
+		result.EnablePlugins = append(result.EnablePlugins, plugin)
+	}
+	for plugin := range disabled {
+		result.DisablePlugins = append(result.DisablePlugins, plugin)
+	}
+	sort.Strings(result.EnablePlugins)
+	sort.Strings(result.DisablePlugins)
+	return result
+}
+
+// admissionArgumentKeys are the kube-apiserver arguments that are managed for the admission configuration.
+var admissionArgumentKeys = []string{"--enable-admission-plugins", "--disable-admission-plugins", "--admission-control-config-file"}
+
+// admissionState records the kube-apiserver admission arguments from before the admission configuration was first
+// applied, so that they can be restored when it is removed. Missing arguments are recorded as nil.
+type admissionState struct {
+	Arguments map[string]*string `json:"arguments"`
+}
+
+// withPlugins returns the comma-separated plugins with add added and remove removed. Existing plugins keep their
+// order, and added plugins are appended in the order given.
+func withPlugins(plugins *string, add []string, remove []string) *string {
+	removed := make(map[string]struct{}, len(remove))
+	for _, plugin := range remove {
+		removed[plugin] = struct{}{}
+	}
+	var result []string
+	seen := make(map[string]struct{})
+	var existing []string
+	if plugins != nil {
+		existing = strings.Split(*plugins, ",")
+	}
+	for _, plugin := range append(existing, add...) {
+		plugin = strings.TrimSpace(plugin)
+		if _, ok := removed[plugin]; ok || plugin == "" {
+			continue
+		}
+		if _, ok := seen[plugin]; ok {
+			continue
+		}
+		seen[plugin] = struct{}{}
+		result = append(result, plugin)
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	value := strings.Join(result, ",")
+	return &value
+}
+
+// admissionArguments returns the kube-apiserver arguments for the admission configuration, starting from the
+// original arguments. Only the listed plugins are enabled or disabled, and the admission configuration file is
+// only replaced if a configuration is specified.
+func (r *Reconciler) admissionArguments(original map[string]*string, admission *microk8sv1alpha1.AdmissionSpec) map[string]*string {
+	enable := append([]string(nil), admission.EnablePlugins...)
+	disable := append([]string(nil), admission.DisablePlugins...)
+	sort.Strings(enable)
+	sort.Strings(disable)
+
+	args := map[string]*string{
+		"--enable-admission-plugins":      withPlugins(original["--enable-admission-plugins"], enable, disable),
+		"--disable-admission-plugins":     withPlugins(original["--disable-admission-plugins"], disable, enable),
+		"--admission-control-config-file": original["--admission-control-config-file"],
+	}
+	if admission.Config != "" {
+		args["--admission-control-config-file"] = r.argumentPath(r.AdmissionConfigFile)
+	}
+	return args
+}
+
+// readAdmissionState returns the recorded original admission arguments, or nil if none are recorded.
+func (r *Reconciler) readAdmissionState() (*admissionState, error) {
+	b, err := os.ReadFile(r.AdmissionStateFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	state := &admissionState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// recordAdmissionState records the current admission arguments of kube-apiserver as the original ones.
+func (r *Reconciler) recordAdmissionState() (*admissionState, error) {
+	current, err := readServiceArguments(r.KubeAPIServerArgsFile)
+	if err != nil {
+		return nil, err
+	}
+	state := &admissionState{Arguments: make(map[string]*string, len(admissionArgumentKeys))}
+	for _, key := range admissionArgumentKeys {
+		if value, ok := current[key]; ok {
+			state.Arguments[key] = &value
+		} else {
+			state.Arguments[key] = nil
+		}
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.AdmissionStateFile), 0700); err != nil {
+		return nil, err
+	}
+	if err := hostfile.WriteAtomic(r.AdmissionStateFile, b, 0600); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// reconcileAdmission writes the admission configuration file and sets the kube-apiserver admission plugin
+// arguments on control plane nodes. The configuration is applied on top of the original arguments of MicroK8s,
+// which are recorded the first time and restored when the admission configuration is removed.
+// Arguments that are set in extraArgs take precedence.
+func (r *Reconciler) reconcileAdmission(ctx context.Context, admission *microk8sv1alpha1.AdmissionSpec, extraArgs map[string]*string) error {
+	if isControlPlane, err := r.isLocalControlPlane(ctx); err != nil || !isControlPlane {
+		return err
+	}
+	log := log.FromContext(ctx)
+
+	state, err := r.readAdmissionState()
+	if err != nil {
+		return fmt.Errorf("failed to read original admission arguments: %w", err)
+	}
+	if admission == nil {
+		if state == nil {
+			return nil
+		}
+		if err := r.resetKubeAPIServerArguments(ctx, "admission", state.Arguments, extraArgs); err != nil {
+			return err
+		}
+		if r.observeOnly() {
+			return nil
+		}
+		if err := os.Remove(r.AdmissionStateFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove original admission arguments: %w", err)
+		}
+		return nil
+	}
+
+	if admission.Config != "" {
+		// an invalid file would prevent kube-apiserver from starting
+		var config map[string]interface{}
+		if err := yaml.Unmarshal([]byte(admission.Config), &config); err != nil {
+			return fmt.Errorf("invalid admission configuration: %w", err)
+		}
+	}
+	if state == nil {
+		if state, err = r.recordAdmissionState(); err != nil {
+			return fmt.Errorf("failed to record original admission arguments: %w", err)
+		}
+	}
+
+	previousConfig, err := snapshotFile(r.AdmissionConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to read admission configuration file: %w", err)
+	}
+	previousArgs, err := snapshotFile(r.KubeAPIServerArgsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read kube-apiserver args file: %w", err)
+	}
+	updated := false
+	if admission.Config != "" {
+		if updated, err = r.updateHostFile(r.AdmissionConfigFile, admission.Config, 0600); err != nil {
+			return fmt.Errorf("failed to update admission configuration file: %w", err)
+		}
+	}
+	args := r.admissionArguments(state.Arguments, admission)
+	for key := range extraArgs {
+		delete(args, key)
+	}
+	argsUpdated, err := updateServiceArguments(r.updateHostFile, r.KubeAPIServerArgsFile, args)
+	if err != nil {
+		return fmt.Errorf("failed to update kube-apiserver args file: %w", err)
+	}
+	if !updated && !argsUpdated {
+		log.Info("admission configuration up to date")
+		return nil
+	}
+	log.Info("updated admission configuration")
+	r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated kube-apiserver admission configuration")
+	r.requestRestart(restartKubelite, "admission configuration", []probe{r.probeKubeAPIServer}, previousConfig, previousArgs)
+	return nil
+}
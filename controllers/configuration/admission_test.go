@@ -0,0 +1,91 @@
+package configuration
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+)
+
+func TestMergeAdmission(t *testing.T) {
+	merged := mergeAdmission(
+		&microk8sv1alpha1.AdmissionSpec{EnablePlugins: []string{"EventRateLimit", "PodSecurity"}, DisablePlugins: []string{"ServiceAccount"}, Config: "default"},
+		&microk8sv1alpha1.AdmissionSpec{EnablePlugins: []string{"ServiceAccount"}, DisablePlugins: []string{"EventRateLimit"}},
+	)
+	expected := &microk8sv1alpha1.AdmissionSpec{
+		EnablePlugins:  []string{"PodSecurity", "ServiceAccount"},
+		DisablePlugins: []string{"EventRateLimit"},
+		Config:         "default",
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, merged)
+	}
+}
+
+func TestReconcileAdmission(t *testing.T) {
+	stock := `--secure-port=16443
+--enable-admission-plugins=EventRateLimit
+--admission-control-config-file=${SNAP}/configs/admission-control-config-file.yaml
+`
+	r := controlPlaneReconciler(t, stock)
+
+	admission := &microk8sv1alpha1.AdmissionSpec{EnablePlugins: []string{"PodSecurity", "AlwaysPullImages"}, DisablePlugins: []string{"DefaultStorageClass"}}
+	if err := r.reconcileAdmission(context.Background(), admission, nil); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	expected := `--secure-port=16443
+--enable-admission-plugins=EventRateLimit,AlwaysPullImages,PodSecurity
+--admission-control-config-file=${SNAP}/configs/admission-control-config-file.yaml
+--disable-admission-plugins=DefaultStorageClass
+`
+	if args := readFile(t, r.KubeAPIServerArgsFile); args != expected {
+		t.Fatalf("Expected arguments %q but got %q", expected, args)
+	}
+
+	// plugins removed from the spec return to their original state
+	r = r.withRestartPlan()
+	admission = &microk8sv1alpha1.AdmissionSpec{DisablePlugins: []string{"EventRateLimit"}, Config: "apiVersion: apiserver.config.k8s.io/v1\nkind: AdmissionConfiguration\n"}
+	if err := r.reconcileAdmission(context.Background(), admission, nil); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	expected = `--secure-port=16443
+--admission-control-config-file=` + r.AdmissionConfigFile + `
+--disable-admission-plugins=EventRateLimit
+`
+	if args := readFile(t, r.KubeAPIServerArgsFile); args != expected {
+		t.Fatalf("Expected arguments %q but got %q", expected, args)
+	}
+
+	r = r.withRestartPlan()
+	if err := r.reconcileAdmission(context.Background(), nil, nil); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	if args := readFile(t, r.KubeAPIServerArgsFile); !reflect.DeepEqual(argumentLines(args), argumentLines(stock)) {
+		t.Fatalf("Expected the original arguments %q but got %q", stock, args)
+	}
+	if _, ok := r.restarts.pending[restartKubelite]; !ok {
+		t.Fatalf("Expected a kubelite restart to be requested")
+	}
+	if _, err := os.Stat(r.AdmissionStateFile); !os.IsNotExist(err) {
+		t.Fatalf("Expected the original admission arguments to be forgotten once restored")
+	}
+
+	r = r.withRestartPlan()
+	if err := r.reconcileAdmission(context.Background(), nil, nil); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	if _, ok := r.restarts.pending[restartKubelite]; ok {
+		t.Fatalf("Expected no restart once the original arguments are restored")
+	}
+}
+
+// argumentLines returns the sorted lines of an arguments file.
+func argumentLines(args string) []string {
+	lines := strings.Split(strings.TrimSpace(args), "\n")
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,108 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// auditArguments returns the kube-apiserver arguments for the audit configuration.
+// Arguments for settings that are not specified are removed.
+func (r *Reconciler) auditArguments(audit *microk8sv1alpha1.AuditSpec) map[string]*string {
+	optional := func(value string) *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+	optionalInt := func(value *int32) *string {
+		if value == nil {
+			return nil
+		}
+		s := strconv.Itoa(int(*value))
+		return &s
+	}
+
+	args := map[string]*string{
+		"--audit-policy-file":         r.argumentPath(r.AuditPolicyFile),
+		"--audit-log-path":            optional(audit.LogPath),
+		"--audit-log-maxage":          optionalInt(audit.LogMaxAge),
+		"--audit-log-maxbackup":       optionalInt(audit.LogMaxBackup),
+		"--audit-log-maxsize":         optionalInt(audit.LogMaxSize),
+		"--audit-webhook-config-file": nil,
+		"--audit-webhook-mode":        nil,
+	}
+	if audit.WebhookConfig != nil {
+		args["--audit-webhook-config-file"] = r.argumentPath(r.AuditWebhookConfigFile)
+		args["--audit-webhook-mode"] = optional(audit.WebhookMode)
+	}
+	return args
+}
+
+// reconcileAudit writes the audit policy and webhook configuration files and sets the kube-apiserver audit
+// arguments. Changes to the referenced ConfigMap and Secret are picked up on the next resync. If audit is not
+// configured, the audit arguments are removed. Arguments that are set in extraArgs take precedence.
+// Worker nodes do not run kube-apiserver, so nothing is done for them.
+func (r *Reconciler) reconcileAudit(ctx context.Context, audit *microk8sv1alpha1.AuditSpec, extraArgs map[string]*string) error {
+	if isControlPlane, err := r.isLocalControlPlane(ctx); err != nil || !isControlPlane {
+		return err
+	}
+	if audit == nil {
+		args := r.auditArguments(&microk8sv1alpha1.AuditSpec{})
+		args["--audit-policy-file"] = nil
+		return r.resetKubeAPIServerArguments(ctx, "audit", args, extraArgs)
+	}
+	log := log.FromContext(ctx)
+
+	policy, err := r.getConfigMapKey(ctx, audit.Policy)
+	if err != nil {
+		return fmt.Errorf("failed to get audit policy: %w", err)
+	}
+	var webhookConfig []byte
+	if audit.WebhookConfig != nil {
+		if webhookConfig, err = r.getSecretKey(ctx, *audit.WebhookConfig); err != nil {
+			return fmt.Errorf("failed to get audit webhook configuration: %w", err)
+		}
+	}
+
+	var previous []fileState
+	for _, file := range []string{r.AuditPolicyFile, r.AuditWebhookConfigFile, r.KubeAPIServerArgsFile} {
+		state, err := snapshotFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		previous = append(previous, state)
+	}
+
+	updated, err := r.updateHostFile(r.AuditPolicyFile, policy, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to update audit policy file: %w", err)
+	}
+	if webhookConfig != nil {
+		webhookUpdated, err := r.updateHostFile(r.AuditWebhookConfigFile, string(webhookConfig), 0600)
+		if err != nil {
+			return fmt.Errorf("failed to update audit webhook configuration file: %w", err)
+		}
+		updated = updated || webhookUpdated
+	}
+	args := r.auditArguments(audit)
+	for key := range extraArgs {
+		delete(args, key)
+	}
+	argsUpdated, err := updateServiceArguments(r.updateHostFile, r.KubeAPIServerArgsFile, args)
+	if err != nil {
+		return fmt.Errorf("failed to update kube-apiserver args file: %w", err)
+	}
+	if !updated && !argsUpdated {
+		log.Info("audit configuration up to date")
+		return nil
+	}
+	log.Info("updated audit configuration")
+	r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated kube-apiserver audit configuration")
+	r.requestRestart(restartKubelite, "audit configuration", []probe{r.probeKubeAPIServer}, previous...)
+	return nil
+}
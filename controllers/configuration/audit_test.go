@@ -0,0 +1,32 @@
+package configuration
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReconcileAuditRemoved(t *testing.T) {
+	r := controlPlaneReconciler(t, `--secure-port=16443
+--audit-policy-file=/var/snap/microk8s/current/args/audit-policy.yaml
+--audit-log-path=/var/log/audit.log
+--audit-log-maxage=30
+`)
+	extraArgs := map[string]*string{"--audit-log-maxage": ptr("30")}
+	if err := r.reconcileAudit(context.Background(), nil, extraArgs); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	if args, expected := readFile(t, r.KubeAPIServerArgsFile), "--secure-port=16443\n--audit-log-maxage=30\n"; args != expected {
+		t.Fatalf("Expected arguments %q but got %q", expected, args)
+	}
+	if _, ok := r.restarts.pending[restartKubelite]; !ok {
+		t.Fatalf("Expected a kubelite restart to be requested")
+	}
+
+	r = r.withRestartPlan()
+	if err := r.reconcileAudit(context.Background(), nil, extraArgs); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	if _, ok := r.restarts.pending[restartKubelite]; ok {
+		t.Fatalf("Expected no restart once the audit arguments are removed")
+	}
+}
@@ -0,0 +1,208 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// withAuthorizationMode returns the authorization modes with mode added or removed. Existing modes keep their order.
+func withAuthorizationMode(modes string, mode string, enabled bool) string {
+	var result []string
+	found := false
+	for _, m := range strings.Split(modes, ",") {
+		m = strings.TrimSpace(m)
+		switch {
+		case m == "":
+		case m != mode:
+			result = append(result, m)
+		case enabled && !found:
+			found = true
+			result = append(result, m)
+		}
+	}
+	if enabled && !found {
+		result = append(result, mode)
+	}
+	return strings.Join(result, ",")
+}
+
+// applyAuthorizationMode adds the Webhook mode to the kube-apiserver --authorization-mode argument of the spec, if
+// the argument is set and an authorization webhook is configured. Otherwise, the Webhook mode is managed by
+// reconcileAuthentication, based on the current arguments of kube-apiserver.
+func applyAuthorizationMode(spec microk8sv1alpha1.ConfigurationSpec) microk8sv1alpha1.ConfigurationSpec {
+	if spec.Authentication == nil || spec.Authentication.AuthorizationWebhookConfig == nil {
+		return spec
+	}
+	existing, ok := spec.ExtraAPIServerArgs["--authorization-mode"]
+	if !ok || existing == nil {
+		return spec
+	}
+	args := make(map[string]*string, len(spec.ExtraAPIServerArgs))
+	for key, val := range spec.ExtraAPIServerArgs {
+		args[key] = val
+	}
+	modes := withAuthorizationMode(*existing, "Webhook", true)
+	args["--authorization-mode"] = &modes
+	spec.ExtraAPIServerArgs = args
+	return spec
+}
+
+// authorizationModeArgument returns the kube-apiserver --authorization-mode argument for the current arguments.
+// The Webhook mode is added if an authorization webhook is configured, and removed if the authorization webhook
+// configured by the operator (webhookFile) is no longer configured. It returns nil if the argument must be left alone.
+func authorizationModeArgument(current map[string]string, webhookFile string, webhook bool) *string {
+	modes, found := current["--authorization-mode"]
+	switch {
+	case webhook:
+	case found && current["--authorization-webhook-config-file"] == webhookFile:
+	default:
+		return nil
+	}
+	newModes := withAuthorizationMode(modes, "Webhook", webhook)
+	if found && newModes == modes {
+		return nil
+	}
+	return &newModes
+}
+
+// authenticationArguments returns the kube-apiserver arguments for the authentication configuration.
+// Arguments for settings that are not specified are removed. The authorization mode is set by authorizationModeArgument.
+func (r *Reconciler) authenticationArguments(auth *microk8sv1alpha1.AuthenticationSpec) map[string]*string {
+	optional := func(value string) *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+
+	args := map[string]*string{
+		"--oidc-issuer-url":                          nil,
+		"--oidc-client-id":                           nil,
+		"--oidc-username-claim":                      nil,
+		"--oidc-username-prefix":                     nil,
+		"--oidc-groups-claim":                        nil,
+		"--oidc-groups-prefix":                       nil,
+		"--oidc-required-claim":                      nil,
+		"--oidc-ca-file":                             nil,
+		"--authentication-token-webhook-config-file": nil,
+		"--authentication-token-webhook-cache-ttl":   nil,
+		"--authorization-webhook-config-file":        nil,
+	}
+	if oidc := auth.OIDC; oidc != nil {
+		args["--oidc-issuer-url"] = optional(oidc.IssuerURL)
+		args["--oidc-client-id"] = optional(oidc.ClientID)
+		args["--oidc-username-claim"] = optional(oidc.UsernameClaim)
+		args["--oidc-username-prefix"] = optional(oidc.UsernamePrefix)
+		args["--oidc-groups-claim"] = optional(oidc.GroupsClaim)
+		args["--oidc-groups-prefix"] = optional(oidc.GroupsPrefix)
+		if len(oidc.RequiredClaims) > 0 {
+			claims := make([]string, 0, len(oidc.RequiredClaims))
+			for key, value := range oidc.RequiredClaims {
+				claims = append(claims, fmt.Sprintf("%s=%s", key, value))
+			}
+			sort.Strings(claims)
+			args["--oidc-required-claim"] = optional(strings.Join(claims, ","))
+		}
+		if oidc.CA != nil {
+			args["--oidc-ca-file"] = r.argumentPath(r.OIDCCAFile)
+		}
+	}
+	if auth.WebhookConfig != nil {
+		args["--authentication-token-webhook-config-file"] = r.argumentPath(r.AuthenticationWebhookConfigFile)
+		if auth.WebhookCacheTTL != nil {
+			args["--authentication-token-webhook-cache-ttl"] = optional(auth.WebhookCacheTTL.Duration.String())
+		}
+	}
+	if auth.AuthorizationWebhookConfig != nil {
+		args["--authorization-webhook-config-file"] = r.argumentPath(r.AuthorizationWebhookConfigFile)
+	}
+	return args
+}
+
+// reconcileAuthentication writes the OIDC CA and webhook configuration files and sets the kube-apiserver
+// authentication arguments on control plane nodes. Changes to the referenced ConfigMap and Secrets are picked up
+// on the next resync. If authentication is not configured, the authentication arguments and the Webhook
+// authorization mode are removed. Arguments that are set in extraArgs are left alone in that case.
+func (r *Reconciler) reconcileAuthentication(ctx context.Context, auth *microk8sv1alpha1.AuthenticationSpec, extraArgs map[string]*string) error {
+	if isControlPlane, err := r.isLocalControlPlane(ctx); err != nil || !isControlPlane {
+		return err
+	}
+	if auth == nil {
+		current, err := readServiceArguments(r.KubeAPIServerArgsFile)
+		if err != nil {
+			return fmt.Errorf("failed to read kube-apiserver args file: %w", err)
+		}
+		args := r.authenticationArguments(&microk8sv1alpha1.AuthenticationSpec{})
+		if modes := authorizationModeArgument(current, *r.argumentPath(r.AuthorizationWebhookConfigFile), false); modes != nil {
+			args["--authorization-mode"] = modes
+		}
+		return r.resetKubeAPIServerArguments(ctx, "authentication", args, extraArgs)
+	}
+	log := log.FromContext(ctx)
+
+	files := make(map[string]string, 3)
+	if auth.OIDC != nil && auth.OIDC.CA != nil {
+		ca, err := r.getConfigMapKey(ctx, *auth.OIDC.CA)
+		if err != nil {
+			return fmt.Errorf("failed to get OIDC CA certificate: %w", err)
+		}
+		files[r.OIDCCAFile] = ca
+	}
+	for file, ref := range map[string]*microk8sv1alpha1.KeyReference{
+		r.AuthenticationWebhookConfigFile: auth.WebhookConfig,
+		r.AuthorizationWebhookConfigFile:  auth.AuthorizationWebhookConfig,
+	} {
+		if ref == nil {
+			continue
+		}
+		config, err := r.getSecretKey(ctx, *ref)
+		if err != nil {
+			return fmt.Errorf("failed to get webhook configuration: %w", err)
+		}
+		files[file] = string(config)
+	}
+
+	var previous []fileState
+	for _, file := range []string{r.OIDCCAFile, r.AuthenticationWebhookConfigFile, r.AuthorizationWebhookConfigFile, r.KubeAPIServerArgsFile} {
+		state, err := snapshotFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		previous = append(previous, state)
+	}
+
+	updated := false
+	for file, contents := range files {
+		fileUpdated, err := r.updateHostFile(file, contents, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", file, err)
+		}
+		updated = updated || fileUpdated
+	}
+	current, err := readServiceArguments(r.KubeAPIServerArgsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read kube-apiserver args file: %w", err)
+	}
+	args := r.authenticationArguments(auth)
+	if modes := authorizationModeArgument(current, *r.argumentPath(r.AuthorizationWebhookConfigFile), auth.AuthorizationWebhookConfig != nil); modes != nil {
+		args["--authorization-mode"] = modes
+	}
+	argsUpdated, err := updateServiceArguments(r.updateHostFile, r.KubeAPIServerArgsFile, args)
+	if err != nil {
+		return fmt.Errorf("failed to update kube-apiserver args file: %w", err)
+	}
+	if !updated && !argsUpdated {
+		log.Info("authentication configuration up to date")
+		return nil
+	}
+	log.Info("updated authentication configuration")
+	r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated kube-apiserver authentication configuration")
+	r.requestRestart(restartKubelite, "authentication configuration", []probe{r.probeKubeAPIServer}, previous...)
+	return nil
+}
@@ -0,0 +1,122 @@
+package configuration
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+)
+
+func TestApplyAuthorizationMode(t *testing.T) {
+	modes := "Node,RBAC"
+	for _, tc := range []struct {
+		name     string
+		spec     microk8sv1alpha1.ConfigurationSpec
+		expected *string
+	}{
+		{
+			name: "enable webhook",
+			spec: microk8sv1alpha1.ConfigurationSpec{
+				Authentication:     &microk8sv1alpha1.AuthenticationSpec{AuthorizationWebhookConfig: &microk8sv1alpha1.KeyReference{}},
+				ExtraAPIServerArgs: map[string]*string{"--authorization-mode": &modes},
+			},
+			expected: ptr("Node,RBAC,Webhook"),
+		},
+		{
+			name: "no webhook",
+			spec: microk8sv1alpha1.ConfigurationSpec{
+				Authentication:     &microk8sv1alpha1.AuthenticationSpec{},
+				ExtraAPIServerArgs: map[string]*string{"--authorization-mode": &modes},
+			},
+			expected: ptr("Node,RBAC"),
+		},
+		{
+			name: "not set",
+			spec: microk8sv1alpha1.ConfigurationSpec{Authentication: &microk8sv1alpha1.AuthenticationSpec{AuthorizationWebhookConfig: &microk8sv1alpha1.KeyReference{}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := applyAuthorizationMode(tc.spec)
+			v := spec.ExtraAPIServerArgs["--authorization-mode"]
+			if (v == nil) != (tc.expected == nil) || (v != nil && *v != *tc.expected) {
+				t.Fatalf("Expected authorization mode %v but got %v", tc.expected, v)
+			}
+		})
+	}
+}
+
+func TestAuthorizationModeArgument(t *testing.T) {
+	webhookFile := "/var/snap/microk8s/current/args/authorization-webhook.kubeconfig"
+	for _, tc := range []struct {
+		name     string
+		current  map[string]string
+		webhook  bool
+		expected *string
+	}{
+		{
+			name:     "enable webhook",
+			current:  map[string]string{"--authorization-mode": "RBAC,Node"},
+			webhook:  true,
+			expected: ptr("RBAC,Node,Webhook"),
+		},
+		{
+			name:    "already enabled",
+			current: map[string]string{"--authorization-mode": "RBAC,Webhook,Node"},
+			webhook: true,
+		},
+		{
+			name:    "no webhook",
+			current: map[string]string{"--authorization-mode": "RBAC,Node"},
+		},
+		{
+			name:    "webhook configured by the user",
+			current: map[string]string{"--authorization-mode": "RBAC,Node,Webhook", "--authorization-webhook-config-file": "/custom.kubeconfig"},
+		},
+		{
+			name:     "webhook removed",
+			current:  map[string]string{"--authorization-mode": "RBAC,Node,Webhook", "--authorization-webhook-config-file": webhookFile},
+			expected: ptr("RBAC,Node"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := authorizationModeArgument(tc.current, webhookFile, tc.webhook)
+			if (v == nil) != (tc.expected == nil) || (v != nil && *v != *tc.expected) {
+				t.Fatalf("Expected authorization mode %v but got %v", tc.expected, v)
+			}
+		})
+	}
+}
+
+func TestReconcileAuthenticationRemoved(t *testing.T) {
+	r := controlPlaneReconciler(t, "")
+	webhookFile := *r.argumentPath(r.AuthorizationWebhookConfigFile)
+	if err := os.WriteFile(r.KubeAPIServerArgsFile, []byte(`--secure-port=16443
+--authorization-mode=RBAC,Node,Webhook
+--authorization-webhook-config-file=`+webhookFile+`
+--oidc-issuer-url=https://issuer
+--oidc-client-id=microk8s
+--authentication-token-webhook-cache-ttl=2m0s
+`), 0600); err != nil {
+		t.Fatalf("Expected no error writing arguments file but received %q", err)
+	}
+	extraArgs := map[string]*string{"--oidc-client-id": ptr("microk8s")}
+	if err := r.reconcileAuthentication(context.Background(), nil, extraArgs); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	expected := "--secure-port=16443\n--authorization-mode=RBAC,Node\n--oidc-client-id=microk8s\n"
+	if args := readFile(t, r.KubeAPIServerArgsFile); args != expected {
+		t.Fatalf("Expected arguments %q but got %q", expected, args)
+	}
+	if _, ok := r.restarts.pending[restartKubelite]; !ok {
+		t.Fatalf("Expected a kubelite restart to be requested")
+	}
+
+	r = r.withRestartPlan()
+	if err := r.reconcileAuthentication(context.Background(), nil, extraArgs); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	if _, ok := r.restarts.pending[restartKubelite]; ok {
+		t.Fatalf("Expected no restart once the authentication arguments are removed")
+	}
+}
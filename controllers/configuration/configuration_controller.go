@@ -19,17 +19,27 @@ package configuration
 import (
 	"context"
 	"fmt"
-	"os"
-	"path/filepath"
+	"time"
 
-	"github.com/go-git/go-git/v5"
 	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // Reconciler reconciles a Configuration object
@@ -37,6 +47,21 @@ type Reconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 
+	// Clientset is used to cordon and drain the node.
+	Clientset kubernetes.Interface
+	// Dynamic is used to rewrite the encrypted resources during an encryption key rotation.
+	Dynamic dynamic.Interface
+
+	// Recorder is used to record events for host actions.
+	Recorder record.EventRecorder
+	events   *nodeEvents
+
+	// restarts collects the service restarts needed during a reconcile pass.
+	restarts *restartPlan
+
+	// drift collects managed files that differ from the desired state, if the drift policy is Observe.
+	drift *driftReport
+
 	// Node information
 	Node string
 
@@ -47,18 +72,95 @@ type Reconciler struct {
 	KubeletArgsFile       string
 	KubeAPIServerArgsFile string
 
-	RefreshCertificates  func(ctx context.Context) error
-	RestartContainerd    func(ctx context.Context) error
-	RestartKubelet       func(ctx context.Context) error
-	RestartKubeAPIServer func(ctx context.Context) error
+	KubeControllerManagerArgsFile string
+	KubeSchedulerArgsFile         string
+	KubeProxyArgsFile             string
+
+	KubeletConfigFile      string
+	AuditPolicyFile        string
+	AuditWebhookConfigFile string
+	EncryptionConfigFile   string
+
+	OIDCCAFile                      string
+	AuthenticationWebhookConfigFile string
+	AuthorizationWebhookConfigFile  string
+	AdmissionConfigFile             string
+	// AdmissionStateFile records the original kube-apiserver admission arguments, which are restored when the
+	// admission configuration is removed.
+	AdmissionStateFile string
+
+	// ArgumentPath returns the path of a file as seen by the services on the host, for use in service arguments.
+	// If nil, paths are used as is.
+	ArgumentPath func(file string) string
+
+	// BackupDir is where backups of managed files are kept before they are overwritten.
+	// BackupRetention is the number of backups to keep for each file. Backups are disabled if either is unset.
+	BackupDir       string
+	BackupRetention int
+
+	RefreshCertificates func(ctx context.Context) error
+	RestartContainerd   func(ctx context.Context) error
+	// RestartKubelite restarts the kubelite service, which runs both kubelet and kube-apiserver.
+	RestartKubelite func(ctx context.Context) error
+
+	// Health probes, used to verify services after a restart. Empty values disable the respective probe.
+	KubeletHealthzURL      string
+	KubeAPIServerReadyzURL string
+	ContainerdSocket       string
+	HealthCheckTimeout     time.Duration
+
+	// ConfigureSnapRefresh holds refreshes of the MicroK8s snap and sets the host-wide snapd refresh
+	// timer. Empty values are left unchanged.
+	// It returns true if the snapd configuration was changed.
+	ConfigureSnapRefresh func(ctx context.Context, hold, timer string) (bool, error)
 
 	// MicroK8s specific information
 	AddonsDir string
+
+	// ImageID returns the ID of an image, or an empty string if the image is not present.
+	// PullImage pulls an image and returns its ID. ImportImage imports an image archive.
+	ImageID     func(ctx context.Context, image string) (string, error)
+	PullImage   func(ctx context.Context, image string) (string, error)
+	ImportImage func(ctx context.Context, file string) error
+
+	// ImagesDir is where image archives are stored while they are imported.
+	ImagesDir string
+	// images runs the image pulls and imports in the background.
+	images *imageJobs
+
+	// ReadinessGate is how new nodes are kept from running workloads until the configuration is first applied.
+	// It is one of ReadinessGateTaint, ReadinessGateCondition, or empty to disable.
+	ReadinessGate string
+	// ReadinessGateWindow is how long after joining the cluster a node that was never configured is still gated.
+	// Older nodes are considered configured. If zero, all nodes that were never configured are gated.
+	ReadinessGateWindow time.Duration
+
+	// Requests are additional requests to apply the configuration, e.g. from node operations.
+	Requests <-chan event.GenericEvent
+
+	// LocalPath returns the path of a file on the host as seen by the node agent.
+	// If nil, paths are used as is.
+	LocalPath func(hostPath string) (string, error)
+
+	// ResyncInterval is the interval after which the configuration is re-applied, so that the node eventually
+	// converges even without changes to the Configuration objects. Zero disables periodic resyncs.
+	ResyncInterval time.Duration
 }
 
 //+kubebuilder:rbac:groups=microk8s.canonical.com,resources=configurations;microk8snodes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=microk8s.canonical.com,resources=configurations/status;microk8snodes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=microk8s.canonical.com,resources=configurations/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=nodes/status,verbs=patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get
+// Node agents can read and update secrets in all namespaces, since one of them rewrites all secrets during an
+// encryption key rotation. Other encrypted resources must be granted to the service account separately.
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;update
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=selfsubjectaccessreviews,verbs=create
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -87,48 +189,189 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 		log.Error(err, "Failed to get config object for node")
 		return ctrl.Result{}, err
 	}
-	spec := mergeConfigSpecs(defaultConfig.Spec, config.Spec)
+	spec := applyAuthorizationMode(applyFeatureGates(mergeConfigSpecs(defaultConfig.Spec, config.Spec)))
+	if r.ReadinessGate == ReadinessGateTaint {
+		spec = withRegisterTaint(spec)
+	}
+	if config.Name != "" {
+		r = r.withEvents(ctx, config)
+	} else {
+		r = r.withEvents(ctx, defaultConfig)
+	}
+	r = r.withDisruptionPolicy(spec.DisruptionPolicy)
+	r = r.withDriftPolicy(spec.DriftPolicy)
+	r = r.withRestartPlan()
+
+	if err := r.holdReadinessGate(ctx); err != nil {
+		log.Error(err, "failed to set readiness gate")
+	}
+
+	var configNames []string
+	for _, c := range []*microk8sv1alpha1.Configuration{defaultConfig, config} {
+		if c.Name != "" {
+			configNames = append(configNames, c.Name)
+		}
+	}
+	hash, err := configHash(spec)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if rolledBackHash, err := r.rolledBackConfigHash(ctx); err != nil {
+		log.Error(err, "failed to get rolled back configuration")
+	} else if rolledBackHash == hash {
+		// applying the same configuration again would only restart the services into the same failure
+		log.Info("not applying configuration that was rolled back", "hash", hash)
+		nodeStatus := microk8sv1alpha1.ConfigurationNodeStatus{
+			Phase:   microk8sv1alpha1.ConfigurationPhaseFailed,
+			Message: fmt.Sprintf("configuration %s was rolled back because a service was unhealthy after applying it, it will not be applied again until it changes", hash),
+		}
+		if err := r.updateNodeStatus(ctx, configNames, nodeStatus); err != nil {
+			log.Error(err, "failed to update configuration status")
+		}
+		return ctrl.Result{}, nil
+	}
 
+	var errs []error
 	if err := r.reconcileContainerdEnv(ctx, spec.ContainerdEnv); err != nil {
 		log.Error(err, "failed to reconcile ContainerdEnv configuration")
+		errs = append(errs, err)
+	}
+	if err := r.reconcileRegistryConfigs(ctx, spec.ContainerdRegistryConfigs); err != nil {
+		log.Error(err, "failed to update registry configuration")
+		errs = append(errs, err)
 	}
-	r.reconcileRegistryConfigs(ctx, spec.ContainerdRegistryConfigs)
 	if err := r.reconcileSANs(ctx, spec.ExtraSANIPs, spec.ExtraSANs); err != nil {
 		log.Error(err, "failed to reconcile SANs")
+		errs = append(errs, err)
 	}
 	if err := r.reconcileKubeletArgs(ctx, spec.ExtraKubeletArgs); err != nil {
 		log.Error(err, "failed to update kubelet arguments")
+		errs = append(errs, err)
+	}
+	if err := r.reconcileKubeletConfig(ctx, spec.KubeletConfig); err != nil {
+		log.Error(err, "failed to update kubelet config file")
+		errs = append(errs, err)
 	}
 	if err := r.reconcileKubeAPIServerArgs(ctx, spec.ExtraAPIServerArgs); err != nil {
 		log.Error(err, "failed to update kube-apiserver arguments")
+		errs = append(errs, err)
 	}
-
-	// TODO(neoaggelos): move this into a reconcileAddonRepositories
-	for _, repo := range spec.AddonRepositories {
-		log := log.WithValues("repository", repo.Name)
-		dir := filepath.Join(r.AddonsDir, repo.Name)
-		if err := os.RemoveAll(dir); err != nil {
-			log.Error(err, "Failed to cleanup dir")
-			continue
+	if err := r.reconcileFeatureGates(ctx, spec.FeatureGates); err != nil {
+		log.Error(err, "failed to update feature gates")
+		errs = append(errs, err)
+	}
+	if err := r.reconcileAuthentication(ctx, spec.Authentication, spec.ExtraAPIServerArgs); err != nil {
+		log.Error(err, "failed to update kube-apiserver authentication configuration")
+		errs = append(errs, err)
+	}
+	if err := r.reconcileAdmission(ctx, spec.Admission, spec.ExtraAPIServerArgs); err != nil {
+		log.Error(err, "failed to update kube-apiserver admission configuration")
+		errs = append(errs, err)
+	}
+	if err := r.reconcileAudit(ctx, spec.Audit, spec.ExtraAPIServerArgs); err != nil {
+		log.Error(err, "failed to update kube-apiserver audit configuration")
+		errs = append(errs, err)
+	}
+	encryptionKeys, err := r.reconcileEncryption(ctx, defaultConfig.Spec.Encryption, defaultConfig.Status.Encryption)
+	if err != nil {
+		log.Error(err, "failed to update encryption configuration")
+		errs = append(errs, err)
+	}
+	restartErr := r.runRestarts(ctx)
+	if restartErr != nil {
+		log.Error(restartErr, "failed to restart services")
+		errs = append(errs, restartErr)
+	}
+	rolledBack := isRollback(restartErr)
+	if rolledBack {
+		if err := r.reportRolledBackConfig(ctx, hash); err != nil {
+			log.Error(err, "failed to report rolled back configuration")
 		}
-
-		if _, err := git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
-			URL:   repo.Repository,
-			Depth: 1,
-		}); err != nil {
-			log.Error(err, "Failed to fetch repository")
-			continue
+	}
+	rotationInProgress := false
+	if defaultConfig.Spec.Encryption != nil && !r.observeOnly() {
+		if encryptionKeys != nil && restartErr == nil {
+			if err := r.reportEncryptionKeys(ctx, encryptionKeys); err != nil {
+				log.Error(err, "failed to report encryption keys")
+				errs = append(errs, err)
+			}
+		}
+		if rotationInProgress, err = r.advanceEncryptionRotation(ctx); err != nil {
+			log.Error(err, "failed to advance encryption key rotation")
+			errs = append(errs, err)
 		}
+	}
+	if err := r.reconcileSnapRefresh(ctx, spec.SnapRefresh); err != nil {
+		log.Error(err, "failed to configure snap refreshes")
+		errs = append(errs, err)
+	}
 
-		log.Info("Configured addon repository")
+	if err := r.reconcileAddonRepositories(ctx, spec.AddonRepositories); err != nil {
+		log.Error(err, "failed to configure addon repositories")
+		errs = append(errs, err)
+	}
+	if err := r.reconcileImages(ctx, spec.Images); err != nil {
+		log.Error(err, "failed to ensure images are present")
+		errs = append(errs, err)
 	}
 
-	return ctrl.Result{}, nil
+	err = kerrors.NewAggregate(errs)
+	nodeStatus := microk8sv1alpha1.ConfigurationNodeStatus{Phase: microk8sv1alpha1.ConfigurationPhaseApplied}
+	if err != nil {
+		r.events.Eventf(corev1.EventTypeWarning, reasonReconcileFailed, "Failed to apply configuration on node %s: %v", r.Node, err)
+		nodeStatus.Phase = microk8sv1alpha1.ConfigurationPhaseFailed
+		nodeStatus.Message = err.Error()
+	} else if drift := r.drift.sorted(); len(drift) > 0 {
+		nodeStatus.Phase = microk8sv1alpha1.ConfigurationPhaseDrifted
+		nodeStatus.Message = fmt.Sprintf("%d managed files differ from the desired state", len(drift))
+		nodeStatus.Drift = drift
+	} else {
+		metrics.LastSuccessfulApply.WithLabelValues(r.Node).SetToCurrentTime()
+		if err := r.reportAppliedConfig(ctx, hash); err != nil {
+			log.Error(err, "failed to report applied configuration")
+		}
+		if err := r.releaseReadinessGate(ctx); err != nil {
+			log.Error(err, "failed to release readiness gate")
+		}
+	}
+	if err := r.updateNodeStatus(ctx, configNames, nodeStatus); err != nil {
+		log.Error(err, "failed to update configuration status")
+	}
+
+	if rolledBack {
+		// the configuration is not retried until it changes, see rolledBackConfigHash
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		// returning the error requeues the request with exponential backoff
+		return ctrl.Result{}, err
+	}
+	if rotationInProgress && (r.ResyncInterval == 0 || r.ResyncInterval > encryptionRequeueInterval) {
+		return ctrl.Result{RequeueAfter: encryptionRequeueInterval}, nil
+	}
+	return ctrl.Result{RequeueAfter: r.ResyncInterval}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
+// Changes to the managed files on the node also trigger a reconcile, so that drift is noticed, and so do the
+// results of image pulls and imports.
 func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&microk8sv1alpha1.Configuration{}).
-		Complete(r)
+	fileEvents := make(chan event.GenericEvent, 1)
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return r.watchManagedFiles(ctx, fileEvents)
+	})); err != nil {
+		return fmt.Errorf("failed to add file watcher: %w", err)
+	}
+
+	imageEvents := make(chan event.GenericEvent, 1)
+	r.images = newImageJobs(imageEvents)
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&microk8sv1alpha1.Configuration{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Channel{Source: fileEvents}, &handler.EnqueueRequestForObject{}).
+		Watches(&source.Channel{Source: imageEvents}, &handler.EnqueueRequestForObject{})
+	if r.Requests != nil {
+		b = b.Watches(&source.Channel{Source: r.Requests}, &handler.EnqueueRequestForObject{})
+	}
+	return b.Complete(r)
 }
@@ -6,6 +6,9 @@ import (
 	"os"
 	"path/filepath"
 
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -15,7 +18,11 @@ func (r *Reconciler) reconcileContainerdEnv(ctx context.Context, env string) err
 		return nil
 	}
 
-	updated, err := updateFile(r.ContainerdEnvFile, env, 0660)
+	previous, err := snapshotFile(r.ContainerdEnvFile)
+	if err != nil {
+		return fmt.Errorf("failed to read containerd environment file: %w", err)
+	}
+	updated, err := r.updateHostFile(r.ContainerdEnvFile, env, 0660)
 	if err != nil {
 		return fmt.Errorf("failed to update containerd environment file: %w", err)
 	}
@@ -24,33 +31,42 @@ func (r *Reconciler) reconcileContainerdEnv(ctx context.Context, env string) err
 		return nil
 	}
 	log.Info("updated containerd environment file")
+	r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated containerd environment file %s", r.ContainerdEnvFile)
 
-	if err := r.RestartContainerd(ctx); err != nil {
-		return fmt.Errorf("failed to restart containerd service: %w", err)
-	}
-	log.Info("restarted containerd service")
+	r.requestRestart(restartContainerd, "containerd environment", []probe{r.probeContainerd, r.probeKubelet}, previous)
 	return nil
 }
 
-func (r *Reconciler) reconcileRegistryConfigs(ctx context.Context, registries map[string]string) {
+func (r *Reconciler) reconcileRegistryConfigs(ctx context.Context, registries map[string]string) error {
 	log := log.FromContext(ctx)
+	var errs []error
 	for registry, toml := range registries {
 		log := log.WithValues("registry", registry)
 		dir := filepath.Join(r.RegistryCertsDir, registry)
+		if r.observeOnly() {
+			if _, err := r.updateHostFile(filepath.Join(dir, "hosts.toml"), toml, 0660); err != nil {
+				errs = append(errs, fmt.Errorf("failed to check hosts.toml for registry %s: %w", registry, err))
+			}
+			continue
+		}
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			log.Error(err, "failed to setup directories")
+			errs = append(errs, fmt.Errorf("failed to setup directories for registry %s: %w", registry, err))
 			continue
 		}
 
-		updated, err := updateFile(filepath.Join(dir, "hosts.toml"), toml, 0660)
+		updated, err := r.updateHostFile(filepath.Join(dir, "hosts.toml"), toml, 0660)
 		if err != nil {
 			log.Error(err, "failed to update hosts.toml")
+			errs = append(errs, fmt.Errorf("failed to update hosts.toml for registry %s: %w", registry, err))
 			continue
 		}
 		if updated {
 			log.Info("updated registry configuration")
+			r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated registry configuration for %s", registry)
 		} else {
 			log.Info("registry configuration is up to date")
 		}
 	}
+	return kerrors.NewAggregate(errs)
 }
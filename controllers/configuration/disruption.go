@@ -0,0 +1,90 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/nodeutil"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultDrainTimeout = 5 * time.Minute
+	defaultReadyTimeout = 5 * time.Minute
+)
+
+// withDisruptionPolicy returns a copy of the reconciler whose service restarts follow the disruption policy.
+func (r *Reconciler) withDisruptionPolicy(policy *microk8sv1alpha1.DisruptionPolicySpec) *Reconciler {
+	if policy == nil || policy.Strategy != microk8sv1alpha1.DisruptionStrategyDrain {
+		return r
+	}
+
+	drainer := &nodeutil.Drainer{
+		Clientset: r.Clientset,
+		Timeout:   defaultDrainTimeout,
+	}
+	if policy.DrainTimeout != nil {
+		drainer.Timeout = policy.DrainTimeout.Duration
+	}
+	readyTimeout := defaultReadyTimeout
+	if policy.ReadyTimeout != nil {
+		readyTimeout = policy.ReadyTimeout.Duration
+	}
+
+	withDrain := func(restart func(context.Context) error) func(context.Context) error {
+		return func(ctx context.Context) error {
+			return r.drainAndRestart(ctx, drainer, readyTimeout, restart)
+		}
+	}
+
+	drained := *r
+	drained.RestartContainerd = withDrain(r.RestartContainerd)
+	drained.RestartKubelite = withDrain(r.RestartKubelite)
+	drained.RefreshCertificates = withDrain(r.RefreshCertificates)
+	return &drained
+}
+
+// drainAndRestart cordons and drains the node, runs restart, waits for the node to become Ready and uncordons it.
+// The node is also uncordoned if the restart fails, since the previous configuration is restored by the caller.
+// If the node does not become Ready in time, it is uncordoned anyway so that it is not left unschedulable
+// indefinitely, and a warning event is recorded.
+func (r *Reconciler) drainAndRestart(ctx context.Context, drainer *nodeutil.Drainer, readyTimeout time.Duration, restart func(context.Context) error) error {
+	log := log.FromContext(ctx)
+
+	if err := drainer.Cordon(ctx, r.Node); err != nil {
+		return fmt.Errorf("failed to cordon node: %w", err)
+	}
+	if err := drainer.Drain(ctx, r.Node); err != nil {
+		// do not leave the node cordoned if we did not disrupt anything
+		if err := drainer.Uncordon(ctx, r.Node); err != nil {
+			log.Error(err, "failed to uncordon node")
+		}
+		return fmt.Errorf("failed to drain node: %w", err)
+	}
+	log.Info("drained node before restart")
+	r.events.Eventf(corev1.EventTypeNormal, reasonNodeDrained, "Drained node %s before restart", r.Node)
+
+	if err := restart(ctx); err != nil {
+		// the restart is rolled back by the caller, do not leave the node cordoned
+		if err := drainer.Uncordon(ctx, r.Node); err != nil {
+			log.Error(err, "failed to uncordon node")
+		}
+		return err
+	}
+	if err := drainer.WaitForReady(ctx, r.Node, time.Now(), readyTimeout); err != nil {
+		r.events.Eventf(corev1.EventTypeWarning, reasonNodeNotReady, "Node %s did not become ready after restart, uncordoning: %v", r.Node, err)
+		if err := drainer.Uncordon(ctx, r.Node); err != nil {
+			log.Error(err, "failed to uncordon node")
+		}
+		return fmt.Errorf("node did not become ready after restart: %w", err)
+	}
+	if err := drainer.Uncordon(ctx, r.Node); err != nil {
+		return fmt.Errorf("failed to uncordon node: %w", err)
+	}
+	log.Info("uncordoned node after restart")
+	r.events.Eventf(corev1.EventTypeNormal, reasonNodeUncordoned, "Uncordoned node %s after restart", r.Node)
+	return nil
+}
@@ -0,0 +1,42 @@
+package configuration
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/neoaggelos/microk8s-operator/pkg/nodeutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDrainAndRestartUncordonsOnFailure(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}})
+	r := &Reconciler{Node: "node"}
+	drainer := &nodeutil.Drainer{Clientset: clientset, Timeout: time.Second}
+
+	restartErr := errors.New("failed to restart")
+	err := r.drainAndRestart(context.Background(), drainer, time.Second, func(ctx context.Context) error {
+		node, err := clientset.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error getting node but received %q", err)
+		}
+		if !node.Spec.Unschedulable {
+			t.Fatalf("Expected node to be cordoned during the restart")
+		}
+		return restartErr
+	})
+	if !errors.Is(err, restartErr) {
+		t.Fatalf("Expected restart error but received %q", err)
+	}
+
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting node but received %q", err)
+	}
+	if node.Spec.Unschedulable {
+		t.Fatalf("Expected node to be uncordoned after the failed restart")
+	}
+}
@@ -0,0 +1,91 @@
+package configuration
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/hostfile"
+)
+
+// driftReport is the list of managed files that differ from the desired state.
+type driftReport struct {
+	files []string
+}
+
+func (d *driftReport) add(file string) {
+	d.files = append(d.files, file)
+}
+
+// sorted returns the list of drifted files in a stable order, suitable for the node status.
+func (d *driftReport) sorted() []string {
+	if d == nil || len(d.files) == 0 {
+		return nil
+	}
+	files := append([]string(nil), d.files...)
+	sort.Strings(files)
+	return files
+}
+
+// withDriftPolicy returns a copy of the reconciler that follows the drift policy.
+// With the Observe policy, host files are not changed and differences are recorded instead.
+func (r *Reconciler) withDriftPolicy(policy string) *Reconciler {
+	if policy != microk8sv1alpha1.DriftPolicyObserve {
+		return r
+	}
+	withDrift := *r
+	withDrift.drift = &driftReport{}
+	return &withDrift
+}
+
+// observeOnly returns true if the reconciler must not change the node.
+func (r *Reconciler) observeOnly() bool {
+	return r.drift != nil
+}
+
+// updateHostFile updates a managed file on the node, keeping a backup of the previous contents. Files that contain
+// secrets are never backed up. With the Observe drift policy, the file is not changed and is recorded as drifted if
+// the contents differ.
+// It returns true if the file was changed.
+func (r *Reconciler) updateHostFile(file string, newContents string, perm fs.FileMode) (bool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
+	if err == nil && string(b) == newContents {
+		return false, nil
+	}
+	if r.observeOnly() {
+		r.drift.add(file)
+		return false, nil
+	}
+	if r.BackupDir != "" && r.BackupRetention > 0 {
+		backups := hostfile.Backups{Dir: r.BackupDir, Retention: r.BackupRetention}
+		if r.isSecretFile(file) {
+			// also purge backups left behind by earlier versions
+			if err := backups.Remove(file); err != nil {
+				return false, fmt.Errorf("failed to remove backups of file: %w", err)
+			}
+		} else if err := backups.Save(file); err != nil {
+			return false, fmt.Errorf("failed to back up file: %w", err)
+		}
+	}
+	return updateFile(file, newContents, perm)
+}
+
+// isSecretFile returns true if file is a managed file that contains secrets, e.g. encryption keys or credentials.
+func (r *Reconciler) isSecretFile(file string) bool {
+	for _, secretFile := range []string{
+		r.EncryptionConfigFile,
+		r.AuthenticationWebhookConfigFile,
+		r.AuthorizationWebhookConfigFile,
+		r.AuditWebhookConfigFile,
+	} {
+		if secretFile != "" && file == secretFile {
+			return true
+		}
+	}
+	return false
+}
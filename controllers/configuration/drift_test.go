@@ -0,0 +1,61 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/hostfile"
+)
+
+func TestUpdateHostFileObserve(t *testing.T) {
+	file := "testdata/drift"
+	if err := os.WriteFile(file, []byte("old"), 0660); err != nil {
+		t.Fatalf("Expected no error setting up file but received %q", err)
+	}
+	defer os.Remove(file)
+
+	r := (&Reconciler{}).withDriftPolicy(microk8sv1alpha1.DriftPolicyObserve)
+	for _, contents := range []string{"old", "new"} {
+		updated, err := r.updateHostFile(file, contents, 0660)
+		if err != nil {
+			t.Fatalf("Expected no error but received %q", err)
+		}
+		if updated {
+			t.Fatalf("Expected file to not be updated")
+		}
+	}
+	if b, _ := os.ReadFile(file); string(b) != "old" {
+		t.Fatalf("Expected file contents to be unchanged but got %q", string(b))
+	}
+	if drift := r.drift.sorted(); !reflect.DeepEqual(drift, []string{file}) {
+		t.Fatalf("Expected drift %v but got %v", []string{file}, drift)
+	}
+}
+
+func TestUpdateHostFileSkipsSecretBackups(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "encryption-config.yaml")
+	file := filepath.Join(dir, "kubelet")
+	for _, f := range []string{secretFile, file} {
+		if err := os.WriteFile(f, []byte("old"), 0600); err != nil {
+			t.Fatalf("Expected no error setting up file but received %q", err)
+		}
+	}
+
+	r := &Reconciler{BackupDir: filepath.Join(dir, "backups"), BackupRetention: 2, EncryptionConfigFile: secretFile}
+	for _, f := range []string{secretFile, file} {
+		if _, err := r.updateHostFile(f, "new", 0600); err != nil {
+			t.Fatalf("Expected no error but received %q", err)
+		}
+	}
+	backups, err := hostfile.Backups{Dir: r.BackupDir}.List()
+	if err != nil {
+		t.Fatalf("Expected no error listing backups but received %q", err)
+	}
+	if len(backups) != 1 || backups[0].File != file {
+		t.Fatalf("Expected only a backup of %q but got %v", file, backups)
+	}
+}
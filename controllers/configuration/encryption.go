@@ -0,0 +1,417 @@
+package configuration
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/nodeutil"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultEncryptionProvider = "aescbc"
+
+	// encryptionRequeueInterval is how often nodes check the progress of a key rotation.
+	encryptionRequeueInterval = 30 * time.Second
+	// rewritePageSize is the number of objects listed at a time when rewriting encrypted resources.
+	rewritePageSize = 500
+)
+
+// nextEncryptionStatus returns the next state of the key rotation, and false if the state does not change.
+// applied is true if all control plane nodes use the keys of the current state. In the RewritingSecrets phase,
+// applied must be true only after all secrets were rewritten.
+func nextEncryptionStatus(activeKey string, status microk8sv1alpha1.EncryptionStatus, applied bool) (microk8sv1alpha1.EncryptionStatus, bool) {
+	next := microk8sv1alpha1.EncryptionStatus{Phase: status.Phase, Keys: status.Keys}
+	switch status.Phase {
+	case "", microk8sv1alpha1.EncryptionPhaseStable:
+		switch {
+		case activeKey == "", len(status.Keys) > 0 && status.Keys[0] == activeKey:
+			return status, false
+		case len(status.Keys) == 0:
+			// enabling encryption, existing secrets must be rewritten once all nodes use the key
+			next.Phase = microk8sv1alpha1.EncryptionPhaseSwitchingPrimary
+			next.Keys = []string{activeKey}
+		default:
+			next.Phase = microk8sv1alpha1.EncryptionPhaseAddingKey
+			next.Keys = []string{status.Keys[0], activeKey}
+		}
+	case microk8sv1alpha1.EncryptionPhaseAddingKey:
+		if !applied {
+			return status, false
+		}
+		next.Phase = microk8sv1alpha1.EncryptionPhaseSwitchingPrimary
+		next.Keys = []string{status.Keys[1], status.Keys[0]}
+	case microk8sv1alpha1.EncryptionPhaseSwitchingPrimary:
+		if !applied {
+			return status, false
+		}
+		next.Phase = microk8sv1alpha1.EncryptionPhaseRewritingSecrets
+	case microk8sv1alpha1.EncryptionPhaseRewritingSecrets:
+		if !applied {
+			return status, false
+		}
+		next.Phase = microk8sv1alpha1.EncryptionPhaseRemovingKey
+		next.Keys = status.Keys[:1]
+	case microk8sv1alpha1.EncryptionPhaseRemovingKey:
+		if !applied {
+			return status, false
+		}
+		next.Phase = microk8sv1alpha1.EncryptionPhaseStable
+	default:
+		return status, false
+	}
+	next.LastTransitionTime = metav1.Now()
+	return next, true
+}
+
+// encryptionResources returns the resources that are encrypted, as "resource.group".
+func encryptionResources(spec *microk8sv1alpha1.EncryptionSpec) []string {
+	if len(spec.Resources) == 0 {
+		return []string{"secrets"}
+	}
+	return spec.Resources
+}
+
+// renderEncryptionConfig renders an EncryptionConfiguration with the named keys, primary key first.
+// The identity provider is always included last, so that data that is not encrypted yet can be read.
+func renderEncryptionConfig(spec *microk8sv1alpha1.EncryptionSpec, names []string, secret map[string][]byte) (string, error) {
+	provider := spec.Provider
+	if provider == "" {
+		provider = defaultEncryptionProvider
+	}
+	resources := encryptionResources(spec)
+
+	keys := make([]map[string]string, 0, len(names))
+	for _, name := range names {
+		key, ok := secret[name]
+		if !ok {
+			return "", fmt.Errorf("encryption key %q not found in secret %s/%s", name, spec.SecretNamespace, spec.SecretName)
+		}
+		keys = append(keys, map[string]string{"name": name, "secret": base64.StdEncoding.EncodeToString(key)})
+	}
+
+	b, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "apiserver.config.k8s.io/v1",
+		"kind":       "EncryptionConfiguration",
+		"resources": []interface{}{
+			map[string]interface{}{
+				"resources": resources,
+				"providers": []interface{}{
+					map[string]interface{}{provider: map[string]interface{}{"keys": keys}},
+					map[string]interface{}{"identity": map[string]interface{}{}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// isLocalControlPlane returns true if the local node runs the control plane services.
+func (r *Reconciler) isLocalControlPlane(ctx context.Context) (bool, error) {
+	node := &corev1.Node{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: r.Node}, node); err != nil {
+		return false, fmt.Errorf("failed to get node: %w", err)
+	}
+	return nodeutil.IsControlPlane(node), nil
+}
+
+// reconcileEncryption writes the EncryptionConfiguration with the keys of the current rotation phase and sets
+// the kube-apiserver --encryption-provider-config argument. It returns the keys that were written, or nil if
+// encryption is not configured, the node is not a control plane node, or it is not the turn of the node yet.
+//
+// Applying new keys restarts kube-apiserver, so control plane nodes take turns by name: a node only applies the
+// keys once all nodes before it report them in their MicroK8sNode status.
+func (r *Reconciler) reconcileEncryption(ctx context.Context, spec *microk8sv1alpha1.EncryptionSpec, status *microk8sv1alpha1.EncryptionStatus) ([]string, error) {
+	if spec == nil || status == nil || len(status.Keys) == 0 {
+		return nil, nil
+	}
+	if isControlPlane, err := r.isLocalControlPlane(ctx); err != nil || !isControlPlane {
+		return nil, err
+	}
+	log := log.FromContext(ctx)
+
+	secret, err := r.Clientset.CoreV1().Secrets(spec.SecretNamespace).Get(ctx, spec.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption keys: %w", err)
+	}
+	contents, err := renderEncryptionConfig(spec, status.Keys, secret.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render encryption configuration: %w", err)
+	}
+
+	current, err := readServiceArguments(r.KubeAPIServerArgsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kube-apiserver args file: %w", err)
+	}
+	existing, err := os.ReadFile(r.EncryptionConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read encryption configuration file: %w", err)
+	}
+	if string(existing) == contents && current["--encryption-provider-config"] == *r.argumentPath(r.EncryptionConfigFile) {
+		log.Info("encryption configuration up to date")
+		return status.Keys, nil
+	}
+	nodes, err := r.encryptionControlPlaneNodes(ctx, status.Keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if node.name == r.Node {
+			break
+		}
+		if !node.applied {
+			log.Info("waiting for control plane node to apply encryption keys", "node", node.name, "keys", status.Keys)
+			return nil, nil
+		}
+	}
+
+	previousConfig, err := snapshotFile(r.EncryptionConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption configuration file: %w", err)
+	}
+	previousArgs, err := snapshotFile(r.KubeAPIServerArgsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kube-apiserver args file: %w", err)
+	}
+	configUpdated, err := r.updateHostFile(r.EncryptionConfigFile, contents, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update encryption configuration file: %w", err)
+	}
+	argsUpdated, err := updateServiceArguments(r.updateHostFile, r.KubeAPIServerArgsFile, map[string]*string{"--encryption-provider-config": r.argumentPath(r.EncryptionConfigFile)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update kube-apiserver args file: %w", err)
+	}
+	if !configUpdated && !argsUpdated {
+		log.Info("encryption configuration up to date")
+		return status.Keys, nil
+	}
+	log.Info("updated encryption configuration", "phase", status.Phase, "keys", status.Keys)
+	r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated encryption configuration with keys %v", status.Keys)
+	r.requestRestart(restartKubelite, "encryption configuration", []probe{r.probeKubeAPIServer}, previousConfig, previousArgs)
+	return status.Keys, nil
+}
+
+// reportEncryptionKeys sets the encryption keys used by kube-apiserver in the status of the local MicroK8sNode.
+func (r *Reconciler) reportEncryptionKeys(ctx context.Context, keys []string) error {
+	return r.updateMicroK8sNodeStatus(ctx, func(status *microk8sv1alpha1.MicroK8sNodeStatus) bool {
+		if reflect.DeepEqual(status.EncryptionKeys, keys) {
+			return false
+		}
+		status.EncryptionKeys = keys
+		return true
+	})
+}
+
+// encryptionNode is a control plane node and whether it uses the keys of the current rotation phase.
+type encryptionNode struct {
+	name    string
+	applied bool
+}
+
+// encryptionControlPlaneNodes returns the control plane nodes with a node agent, sorted by name, and whether each
+// of them uses the specified keys.
+func (r *Reconciler) encryptionControlPlaneNodes(ctx context.Context, keys []string) ([]encryptionNode, error) {
+	microk8sNodes := &microk8sv1alpha1.MicroK8sNodeList{}
+	if err := r.Client.List(ctx, microk8sNodes); err != nil {
+		return nil, fmt.Errorf("failed to list microk8s nodes: %w", err)
+	}
+	var nodes []encryptionNode
+	for _, microk8sNode := range microk8sNodes.Items {
+		node := &corev1.Node{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: microk8sNode.Name}, node); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get node %s: %w", microk8sNode.Name, err)
+		}
+		if !nodeutil.IsControlPlane(node) {
+			continue
+		}
+		nodes = append(nodes, encryptionNode{name: node.Name, applied: reflect.DeepEqual(microk8sNode.Status.EncryptionKeys, keys)})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+	return nodes, nil
+}
+
+// allApplied returns true if there are control plane nodes and all of them use the keys of the current phase.
+func allApplied(nodes []encryptionNode) bool {
+	for _, node := range nodes {
+		if !node.applied {
+			return false
+		}
+	}
+	return len(nodes) > 0
+}
+
+// rewriteResources rewrites all objects of the encrypted resources, so that they are encrypted with the primary key.
+func (r *Reconciler) rewriteResources(ctx context.Context, resources []string) error {
+	for _, resource := range resources {
+		if strings.Contains(resource, "*") {
+			return fmt.Errorf("cannot rewrite wildcard resource %q", resource)
+		}
+		gvr, err := r.Client.RESTMapper().ResourceFor(schema.ParseGroupResource(resource).WithVersion(""))
+		if err != nil {
+			return fmt.Errorf("failed to find resource %q: %w", resource, err)
+		}
+		if err := r.rewriteResource(ctx, gvr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteResource rewrites all objects of a resource. Objects are listed in pages of rewritePageSize.
+func (r *Reconciler) rewriteResource(ctx context.Context, gvr schema.GroupVersionResource) error {
+	opts := metav1.ListOptions{Limit: rewritePageSize}
+	for {
+		list, err := r.Dynamic.Resource(gvr).List(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", gvr.GroupResource(), err)
+		}
+		for _, item := range list.Items {
+			item := item
+			var err error
+			if namespace := item.GetNamespace(); namespace != "" {
+				_, err = r.Dynamic.Resource(gvr).Namespace(namespace).Update(ctx, &item, metav1.UpdateOptions{})
+			} else {
+				_, err = r.Dynamic.Resource(gvr).Update(ctx, &item, metav1.UpdateOptions{})
+			}
+			// objects that were changed or deleted in the meantime do not need to be rewritten
+			if err != nil && !apierrors.IsConflict(err) && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to rewrite %s %s/%s: %w", gvr.GroupResource(), item.GetNamespace(), item.GetName(), err)
+			}
+		}
+		if opts.Continue = list.GetContinue(); opts.Continue == "" {
+			return nil
+		}
+	}
+}
+
+// checkRewriteAccess returns an error if the node agent is not allowed to list and update the resources, which is
+// required to rewrite them during a key rotation.
+func (r *Reconciler) checkRewriteAccess(ctx context.Context, resources []string) error {
+	for _, resource := range resources {
+		if strings.Contains(resource, "*") {
+			return fmt.Errorf("cannot rewrite wildcard resource %q", resource)
+		}
+		gr := schema.ParseGroupResource(resource)
+		for _, verb := range []string{"list", "update"} {
+			review, err := r.Clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: verb, Group: gr.Group, Resource: gr.Resource},
+				},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to check access to %s: %w", resource, err)
+			}
+			if !review.Status.Allowed {
+				return fmt.Errorf("node agent is not allowed to %s %s, grant access to its service account or remove it from the encrypted resources", verb, resource)
+			}
+		}
+	}
+	return nil
+}
+
+// advanceEncryptionRotation moves the key rotation to the next phase once all control plane nodes have applied
+// the keys of the current phase. Encrypted resources are rewritten by the first control plane node, by name.
+// It returns true while a rotation is in progress.
+func (r *Reconciler) advanceEncryptionRotation(ctx context.Context) (bool, error) {
+	log := log.FromContext(ctx)
+	config := &microk8sv1alpha1.Configuration{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: "default"}, config); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if config.Spec.Encryption == nil {
+		return false, nil
+	}
+	status := microk8sv1alpha1.EncryptionStatus{}
+	if config.Status.Encryption != nil {
+		status = *config.Status.Encryption
+	}
+
+	nodes, err := r.encryptionControlPlaneNodes(ctx, status.Keys)
+	if err != nil {
+		return false, fmt.Errorf("failed to advance encryption key rotation: %w", err)
+	}
+	applied := allApplied(nodes)
+	if status.Phase == microk8sv1alpha1.EncryptionPhaseRewritingSecrets {
+		if len(nodes) == 0 || nodes[0].name != r.Node {
+			return true, nil
+		}
+		resources := encryptionResources(config.Spec.Encryption)
+		if err := r.checkRewriteAccess(ctx, resources); err != nil {
+			r.events.Eventf(corev1.EventTypeWarning, reasonEncryptionRotation, "Cannot rewrite encrypted resources: %v", err)
+			return true, fmt.Errorf("failed to advance encryption key rotation: %w", err)
+		}
+		if err := r.rewriteResources(ctx, resources); err != nil {
+			return true, fmt.Errorf("failed to advance encryption key rotation: %w", err)
+		}
+		log.Info("rewrote all encrypted resources with the new encryption key")
+		applied = true
+	}
+
+	next, changed := nextEncryptionStatus(config.Spec.Encryption.ActiveKey, status, applied)
+	inProgress := next.Phase != "" && next.Phase != microk8sv1alpha1.EncryptionPhaseStable
+	if !changed {
+		return inProgress, nil
+	}
+	if status.Phase == "" || status.Phase == microk8sv1alpha1.EncryptionPhaseStable {
+		// do not start a rotation that cannot complete
+		if err := r.checkRewriteAccess(ctx, encryptionResources(config.Spec.Encryption)); err != nil {
+			r.events.Eventf(corev1.EventTypeWarning, reasonEncryptionRotation, "Not starting encryption key rotation: %v", err)
+			return false, fmt.Errorf("refusing to start encryption key rotation: %w", err)
+		}
+	}
+	updated := false
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &microk8sv1alpha1.Configuration{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: "default"}, latest); err != nil {
+			return err
+		}
+		if !sameEncryptionPhase(latest.Status.Encryption, status) {
+			// another node advanced the rotation in the meantime
+			return nil
+		}
+		latest.Status.Encryption = &next
+		if err := r.Client.Status().Update(ctx, latest); err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	if err != nil {
+		return inProgress, fmt.Errorf("failed to advance encryption key rotation: %w", err)
+	}
+	if updated {
+		log.Info("encryption key rotation phase changed", "phase", next.Phase, "keys", next.Keys)
+		r.events.Eventf(corev1.EventTypeNormal, reasonEncryptionRotation, "Encryption key rotation phase changed to %s with keys %v", next.Phase, next.Keys)
+	}
+	return inProgress, nil
+}
+
+// sameEncryptionPhase returns true if the encryption status has the same phase and keys as expected.
+func sameEncryptionPhase(status *microk8sv1alpha1.EncryptionStatus, expected microk8sv1alpha1.EncryptionStatus) bool {
+	if status == nil {
+		return expected.Phase == "" && len(expected.Keys) == 0
+	}
+	return status.Phase == expected.Phase && reflect.DeepEqual(status.Keys, expected.Keys)
+}
@@ -0,0 +1,194 @@
+package configuration
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/nodeutil"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNextEncryptionStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		activeKey string
+		status    microk8sv1alpha1.EncryptionStatus
+		expected  []microk8sv1alpha1.EncryptionStatus
+	}{
+		{
+			name:      "enable",
+			activeKey: "key1",
+			expected: []microk8sv1alpha1.EncryptionStatus{
+				{Phase: microk8sv1alpha1.EncryptionPhaseSwitchingPrimary, Keys: []string{"key1"}},
+				{Phase: microk8sv1alpha1.EncryptionPhaseRewritingSecrets, Keys: []string{"key1"}},
+				{Phase: microk8sv1alpha1.EncryptionPhaseRemovingKey, Keys: []string{"key1"}},
+				{Phase: microk8sv1alpha1.EncryptionPhaseStable, Keys: []string{"key1"}},
+			},
+		},
+		{
+			name:      "rotate",
+			activeKey: "key2",
+			status:    microk8sv1alpha1.EncryptionStatus{Phase: microk8sv1alpha1.EncryptionPhaseStable, Keys: []string{"key1"}},
+			expected: []microk8sv1alpha1.EncryptionStatus{
+				{Phase: microk8sv1alpha1.EncryptionPhaseAddingKey, Keys: []string{"key1", "key2"}},
+				{Phase: microk8sv1alpha1.EncryptionPhaseSwitchingPrimary, Keys: []string{"key2", "key1"}},
+				{Phase: microk8sv1alpha1.EncryptionPhaseRewritingSecrets, Keys: []string{"key2", "key1"}},
+				{Phase: microk8sv1alpha1.EncryptionPhaseRemovingKey, Keys: []string{"key2"}},
+				{Phase: microk8sv1alpha1.EncryptionPhaseStable, Keys: []string{"key2"}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status := tc.status
+			for _, expected := range tc.expected {
+				if _, changed := nextEncryptionStatus(tc.activeKey, status, false); changed && status.Phase != microk8sv1alpha1.EncryptionPhaseStable && status.Phase != "" {
+					t.Fatalf("Expected phase %q to wait for all nodes", status.Phase)
+				}
+				next, changed := nextEncryptionStatus(tc.activeKey, status, true)
+				if !changed {
+					t.Fatalf("Expected phase %q to change", status.Phase)
+				}
+				if next.Phase != expected.Phase || !reflect.DeepEqual(next.Keys, expected.Keys) {
+					t.Fatalf("Expected %s %v but got %s %v", expected.Phase, expected.Keys, next.Phase, next.Keys)
+				}
+				status = next
+			}
+			if _, changed := nextEncryptionStatus(tc.activeKey, status, true); changed {
+				t.Fatalf("Expected no change after rotation completed")
+			}
+		})
+	}
+}
+
+func TestRewriteResources(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	objects := []runtime.Object{
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "kube-system"}},
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(scheme, objects...)
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	r := &Reconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).Build(), Dynamic: dynamicClient}
+
+	if err := r.rewriteResources(context.Background(), []string{"secrets", "configmaps"}); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	updated := map[string]int{}
+	for _, action := range dynamicClient.Actions() {
+		if action.GetVerb() == "update" {
+			updated[action.GetResource().Resource]++
+		}
+	}
+	if expected := map[string]int{"secrets": 1, "configmaps": 2}; !reflect.DeepEqual(updated, expected) {
+		t.Fatalf("Expected updates %v but got %v", expected, updated)
+	}
+
+	if err := r.rewriteResources(context.Background(), []string{"*.*"}); err == nil {
+		t.Fatalf("Expected an error for wildcard resources but did not receive one")
+	}
+}
+
+func TestReconcileEncryptionTakesTurns(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = microk8sv1alpha1.AddToScheme(scheme)
+
+	var objects []client.Object
+	for _, name := range []string{"cp-1", "cp-2"} {
+		objects = append(objects,
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{nodeutil.ControlPlaneLabel: ""}}},
+			&microk8sv1alpha1.MicroK8sNode{ObjectMeta: metav1.ObjectMeta{Name: name}},
+		)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	clientset := kubefake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: "kube-system"},
+		Data:       map[string][]byte{"key1": []byte("0123456789abcdef0123456789abcdef")},
+	})
+
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "kube-apiserver")
+	if err := os.WriteFile(argsFile, []byte("--secure-port=16443\n"), 0600); err != nil {
+		t.Fatalf("Expected no error writing arguments file but received %q", err)
+	}
+	r := (&Reconciler{
+		Client:                c,
+		Clientset:             clientset,
+		Node:                  "cp-2",
+		KubeAPIServerArgsFile: argsFile,
+		EncryptionConfigFile:  filepath.Join(dir, "encryption-config.yaml"),
+	}).withRestartPlan()
+	spec := &microk8sv1alpha1.EncryptionSpec{SecretNamespace: "kube-system", SecretName: "keys", ActiveKey: "key1"}
+	status := &microk8sv1alpha1.EncryptionStatus{Phase: microk8sv1alpha1.EncryptionPhaseSwitchingPrimary, Keys: []string{"key1"}}
+
+	keys, err := r.reconcileEncryption(context.Background(), spec, status)
+	if err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	if keys != nil {
+		t.Fatalf("Expected cp-2 to wait for cp-1 but it applied keys %v", keys)
+	}
+	if _, err := os.Stat(r.EncryptionConfigFile); !os.IsNotExist(err) {
+		t.Fatalf("Expected no encryption configuration to be written before the turn of the node")
+	}
+
+	cp1 := &microk8sv1alpha1.MicroK8sNode{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "cp-1"}, cp1); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	cp1.Status.EncryptionKeys = []string{"key1"}
+	if err := c.Status().Update(context.Background(), cp1); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+
+	keys, err = r.reconcileEncryption(context.Background(), spec, status)
+	if err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"key1"}) {
+		t.Fatalf("Expected cp-2 to apply keys [key1] but got %v", keys)
+	}
+	if _, ok := r.restarts.pending[restartKubelite]; !ok {
+		t.Fatalf("Expected a kubelite restart to be requested")
+	}
+}
+
+func TestCheckRewriteAccess(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Resource == "secrets"
+		return true, review, nil
+	})
+	r := &Reconciler{Clientset: clientset}
+
+	if err := r.checkRewriteAccess(context.Background(), []string{"secrets"}); err != nil {
+		t.Fatalf("Expected no error for secrets but received %q", err)
+	}
+	for _, resources := range [][]string{{"secrets", "configmaps"}, {"*.apps"}} {
+		if err := r.checkRewriteAccess(context.Background(), resources); err == nil {
+			t.Fatalf("Expected an error for %v but received none", resources)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package configuration
+
+import (
+	"context"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Event reasons for host actions taken by the reconciler.
+const (
+	reasonFileUpdated           = "FileUpdated"
+	reasonServiceRestarted      = "ServiceRestarted"
+	reasonCertificatesRefreshed = "CertificatesRefreshed"
+	reasonRolledBack            = "RolledBack"
+	reasonNodeDrained           = "NodeDrained"
+	reasonNodeUncordoned        = "NodeUncordoned"
+	reasonNodeNotReady          = "NodeNotReady"
+	reasonAddonRepoFetched      = "AddonRepositoryFetched"
+	reasonAddonRepoFailed       = "AddonRepositoryFetchFailed"
+	reasonSnapRefreshConfigured = "SnapRefreshConfigured"
+	reasonReconcileFailed       = "ReconcileFailed"
+	reasonEncryptionRotation    = "EncryptionKeyRotation"
+	reasonImagePulled           = "ImagePulled"
+	reasonImageImported         = "ImageImported"
+	reasonImageFailed           = "ImageFailed"
+	reasonNodeConfigured        = "NodeConfigured"
+)
+
+// nodeEvents records events against the Configuration and the MicroK8sNode of the current reconcile pass.
+type nodeEvents struct {
+	recorder record.EventRecorder
+	objects  []runtime.Object
+}
+
+func (e *nodeEvents) Eventf(eventtype, reason, messageFmt string, args ...interface{}) {
+	if e == nil || e.recorder == nil {
+		return
+	}
+	for _, obj := range e.objects {
+		e.recorder.Eventf(obj, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// withEvents returns a copy of the reconciler that records events against the configuration and the local node.
+// If no MicroK8sNode exists for the local node, events are recorded against the Kubernetes Node instead.
+func (r *Reconciler) withEvents(ctx context.Context, config *microk8sv1alpha1.Configuration) *Reconciler {
+	events := &nodeEvents{recorder: r.Recorder}
+	if config.Name != "" {
+		events.objects = append(events.objects, config)
+	}
+
+	node := &microk8sv1alpha1.MicroK8sNode{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: r.Node}, node); err == nil {
+		events.objects = append(events.objects, node)
+	} else if client.IgnoreNotFound(err) == nil {
+		events.objects = append(events.objects, &corev1.ObjectReference{Kind: "Node", Name: r.Node, UID: types.UID(r.Node)})
+	}
+
+	withEvents := *r
+	withEvents.events = events
+	return &withEvents
+}
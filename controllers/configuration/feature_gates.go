@@ -0,0 +1,146 @@
+package configuration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func mergeFeatureGates(base, overrides map[string]bool) map[string]bool {
+	if len(base) == 0 && len(overrides) == 0 {
+		return nil
+	}
+	m := make(map[string]bool, len(base)+len(overrides))
+	for key, val := range base {
+		m[key] = val
+	}
+	for key, val := range overrides {
+		m[key] = val
+	}
+	return m
+}
+
+// parseFeatureGates parses a --feature-gates value, e.g. "A=true,B=false". Invalid entries are ignored.
+func parseFeatureGates(value string) map[string]bool {
+	gates := make(map[string]bool)
+	for _, gate := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(gate), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(parts[1]))
+		if err != nil {
+			continue
+		}
+		gates[strings.TrimSpace(parts[0])] = enabled
+	}
+	return gates
+}
+
+// formatFeatureGates formats feature gates as a --feature-gates value, sorted by name.
+func formatFeatureGates(gates map[string]bool) string {
+	entries := make([]string, 0, len(gates))
+	for key, val := range gates {
+		entries = append(entries, fmt.Sprintf("%s=%v", key, val))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// withFeatureGateArgument returns args with the feature gates added to the --feature-gates argument.
+// Feature gates already in the argument take precedence. An argument that is explicitly removed is left as is.
+func withFeatureGateArgument(args map[string]*string, gates map[string]bool) map[string]*string {
+	result := make(map[string]*string, len(args)+1)
+	for key, val := range args {
+		result[key] = val
+	}
+	existing, ok := result["--feature-gates"]
+	if ok && existing == nil {
+		return result
+	}
+	if ok {
+		gates = mergeFeatureGates(gates, parseFeatureGates(*existing))
+	}
+	value := formatFeatureGates(gates)
+	result["--feature-gates"] = &value
+	return result
+}
+
+// withFeatureGateField returns the KubeletConfiguration with the feature gates added to the featureGates field.
+// Feature gates already in the KubeletConfiguration take precedence.
+func withFeatureGateField(raw *runtime.RawExtension, gates map[string]bool) *runtime.RawExtension {
+	b, err := json.Marshal(map[string]interface{}{"featureGates": gates})
+	if err != nil {
+		return raw
+	}
+	return mergeRawObjects(&runtime.RawExtension{Raw: b}, raw)
+}
+
+// applyFeatureGates renders the feature gates of the spec into the configuration of each component.
+func applyFeatureGates(spec microk8sv1alpha1.ConfigurationSpec) microk8sv1alpha1.ConfigurationSpec {
+	if len(spec.FeatureGates) == 0 {
+		return spec
+	}
+	if spec.KubeletConfig != nil {
+		spec.KubeletConfig = withFeatureGateField(spec.KubeletConfig, spec.FeatureGates)
+	} else {
+		spec.ExtraKubeletArgs = withFeatureGateArgument(spec.ExtraKubeletArgs, spec.FeatureGates)
+	}
+	spec.ExtraAPIServerArgs = withFeatureGateArgument(spec.ExtraAPIServerArgs, spec.FeatureGates)
+	return spec
+}
+
+// reconcileFeatureGates sets the feature gates in the arguments files of kube-controller-manager, kube-scheduler and
+// kube-proxy, which have no extra arguments in the spec. Kubelet and kube-apiserver are handled by applyFeatureGates.
+// Arguments files that do not exist on the node are skipped.
+func (r *Reconciler) reconcileFeatureGates(ctx context.Context, gates map[string]bool) error {
+	if len(gates) == 0 {
+		return nil
+	}
+	log := log.FromContext(ctx)
+	value := formatFeatureGates(gates)
+
+	var errs []error
+	for _, component := range []struct {
+		name string
+		file string
+	}{
+		{name: "kube-controller-manager", file: r.KubeControllerManagerArgsFile},
+		{name: "kube-scheduler", file: r.KubeSchedulerArgsFile},
+		{name: "kube-proxy", file: r.KubeProxyArgsFile},
+	} {
+		if component.file == "" {
+			continue
+		}
+		if _, err := os.Stat(component.file); os.IsNotExist(err) {
+			continue
+		}
+		previous, err := snapshotFile(component.file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read %s args file: %w", component.name, err))
+			continue
+		}
+		updated, err := updateServiceArguments(r.updateHostFile, component.file, map[string]*string{"--feature-gates": &value})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to update %s args file: %w", component.name, err))
+			continue
+		}
+		if !updated {
+			continue
+		}
+		log.Info("updated feature gates", "component", component.name)
+		r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated %s arguments file %s", component.name, component.file)
+		r.requestRestart(restartKubelite, component.name+" feature gates", nil, previous)
+	}
+	return kerrors.NewAggregate(errs)
+}
@@ -0,0 +1,83 @@
+package configuration
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestApplyFeatureGates(t *testing.T) {
+	explicit := "B=true"
+	spec := applyFeatureGates(microk8sv1alpha1.ConfigurationSpec{
+		FeatureGates:       map[string]bool{"A": true, "B": false},
+		ExtraAPIServerArgs: map[string]*string{"--feature-gates": &explicit},
+	})
+	if v := spec.ExtraAPIServerArgs["--feature-gates"]; v == nil || *v != "A=true,B=true" {
+		t.Fatalf("Expected kube-apiserver feature gates %q but got %v", "A=true,B=true", v)
+	}
+	if v := spec.ExtraKubeletArgs["--feature-gates"]; v == nil || *v != "A=true,B=false" {
+		t.Fatalf("Expected kubelet feature gates %q but got %v", "A=true,B=false", v)
+	}
+
+	spec = applyFeatureGates(microk8sv1alpha1.ConfigurationSpec{
+		FeatureGates:  map[string]bool{"A": true, "B": false},
+		KubeletConfig: &runtime.RawExtension{Raw: []byte(`{"featureGates":{"B":true}}`)},
+	})
+	if _, ok := spec.ExtraKubeletArgs["--feature-gates"]; ok {
+		t.Fatalf("Expected no kubelet --feature-gates argument with a kubelet config")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(spec.KubeletConfig.Raw, &config); err != nil {
+		t.Fatalf("Expected no error decoding kubelet config but received %q", err)
+	}
+	expected := map[string]interface{}{"A": true, "B": true}
+	if !reflect.DeepEqual(config["featureGates"], expected) {
+		t.Fatalf("Expected kubelet config feature gates %v but got %v", expected, config["featureGates"])
+	}
+}
+
+func TestReconcileFeatureGates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"kube-controller-manager": "--leader-elect-lease-duration=60s\n--feature-gates=C=true\n",
+		"kube-proxy":              "--cluster-cidr=10.1.0.0/16\n",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatalf("Expected no error writing %s but received %q", name, err)
+		}
+	}
+	r := (&Reconciler{
+		KubeControllerManagerArgsFile: filepath.Join(dir, "kube-controller-manager"),
+		KubeSchedulerArgsFile:         filepath.Join(dir, "kube-scheduler"),
+		KubeProxyArgsFile:             filepath.Join(dir, "kube-proxy"),
+	}).withRestartPlan()
+
+	if err := r.reconcileFeatureGates(context.Background(), map[string]bool{"A": true, "B": false}); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	for name, expected := range map[string]string{
+		"kube-controller-manager": "--leader-elect-lease-duration=60s\n--feature-gates=A=true,B=false\n",
+		"kube-proxy":              "--cluster-cidr=10.1.0.0/16\n--feature-gates=A=true,B=false\n",
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Expected no error reading %s but received %q", name, err)
+		}
+		if string(b) != expected {
+			t.Fatalf("Expected %s arguments %q but got %q", name, expected, string(b))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "kube-scheduler")); !os.IsNotExist(err) {
+		t.Fatalf("Expected missing kube-scheduler arguments file to be skipped")
+	}
+	if _, ok := r.restarts.pending[restartKubelite]; !ok {
+		t.Fatalf("Expected a kubelite restart to be requested")
+	}
+}
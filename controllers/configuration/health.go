@@ -0,0 +1,172 @@
+package configuration
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultHealthCheckTimeout = 2 * time.Minute
+	healthCheckInterval       = 2 * time.Second
+)
+
+// probe checks whether a host service is healthy.
+type probe func(ctx context.Context) error
+
+// rollbackError is returned when a service was unhealthy after a restart and the changes were rolled back.
+type rollbackError struct {
+	service string
+	err     error
+}
+
+func (e *rollbackError) Error() string {
+	return fmt.Sprintf("%s was unhealthy after restart and changes were rolled back: %v", e.service, e.err)
+}
+
+func (e *rollbackError) Unwrap() error {
+	return e.err
+}
+
+// isRollback returns true if err is a *rollbackError, or an aggregate that contains one.
+func isRollback(err error) bool {
+	var agg kerrors.Aggregate
+	if errors.As(err, &agg) {
+		for _, err := range agg.Errors() {
+			if isRollback(err) {
+				return true
+			}
+		}
+		return false
+	}
+	var rollbackErr *rollbackError
+	return errors.As(err, &rollbackErr)
+}
+
+// restartAndVerify restarts a service and waits until all probes pass. If the probes do not pass within
+// HealthCheckTimeout, the files are restored to their previous contents, the service is restarted again
+// and a *rollbackError is returned.
+func (r *Reconciler) restartAndVerify(ctx context.Context, service string, restart func(context.Context) error, probes []probe, previous ...fileState) error {
+	log := log.FromContext(ctx).WithValues("service", service)
+
+	if err := restart(ctx); err != nil {
+		return err
+	}
+	probeErr := r.waitForHealthy(ctx, probes)
+	if probeErr == nil {
+		return nil
+	}
+	log.Error(probeErr, "service is unhealthy after restart, rolling back")
+
+	// restore in reverse order, in case the same file was changed more than once
+	for i := len(previous) - 1; i >= 0; i-- {
+		file := previous[i]
+		if err := file.restore(); err != nil {
+			return fmt.Errorf("failed to roll back %s after failed health check (%v): %w", file.path, probeErr, err)
+		}
+	}
+	if err := restart(ctx); err != nil {
+		return fmt.Errorf("failed to restart after rolling back failed health check (%v): %w", probeErr, err)
+	}
+	log.Info("rolled back changes")
+	r.events.Eventf(corev1.EventTypeWarning, reasonRolledBack, "Rolled back changes because %s was unhealthy after restart: %v", service, probeErr)
+	return &rollbackError{service: service, err: probeErr}
+}
+
+// waitForHealthy waits until all probes pass, or returns the last probe error after HealthCheckTimeout.
+func (r *Reconciler) waitForHealthy(ctx context.Context, probes []probe) error {
+	timeout := r.HealthCheckTimeout
+	if timeout == 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		var err error
+		for _, p := range probes {
+			if err = p(ctx); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(healthCheckInterval):
+		}
+	}
+}
+
+// probeKubelet checks the kubelet healthz endpoint.
+func (r *Reconciler) probeKubelet(ctx context.Context) error {
+	if r.KubeletHealthzURL == "" {
+		return nil
+	}
+	if err := probeHTTP(ctx, r.KubeletHealthzURL); err != nil {
+		return fmt.Errorf("kubelet is not healthy: %w", err)
+	}
+	return nil
+}
+
+// probeKubeAPIServer checks the kube-apiserver readyz endpoint.
+func (r *Reconciler) probeKubeAPIServer(ctx context.Context) error {
+	if r.KubeAPIServerReadyzURL == "" {
+		return nil
+	}
+	if err := probeHTTP(ctx, r.KubeAPIServerReadyzURL); err != nil {
+		return fmt.Errorf("kube-apiserver is not ready: %w", err)
+	}
+	return nil
+}
+
+// probeContainerd checks that the containerd socket accepts connections.
+func (r *Reconciler) probeContainerd(ctx context.Context) error {
+	if r.ContainerdSocket == "" {
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", r.ContainerdSocket)
+	if err != nil {
+		return fmt.Errorf("containerd is not healthy: %w", err)
+	}
+	return conn.Close()
+}
+
+var probeClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		// the probes only check that the local services respond, the server certificates are not verified.
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	},
+}
+
+// probeHTTP returns nil if the endpoint responds with 200 OK. Responses with 401 or 403 are also accepted,
+// since they mean that the service is up but anonymous requests are not allowed.
+func probeHTTP(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusUnauthorized, http.StatusForbidden:
+		return nil
+	default:
+		return errors.New(resp.Status)
+	}
+}
@@ -0,0 +1,95 @@
+package configuration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+func TestRestartAndVerify(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		probeErr         error
+		expectedRestarts int
+		expectedContents string
+		expectRollback   bool
+	}{
+		{
+			name:             "healthy",
+			expectedRestarts: 1,
+			expectedContents: "new",
+		},
+		{
+			name:             "unhealthy",
+			probeErr:         errors.New("not ready"),
+			expectedRestarts: 2,
+			expectedContents: "old",
+			expectRollback:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := "testdata/" + tc.name
+			if err := os.WriteFile(file, []byte("old"), 0660); err != nil {
+				t.Fatalf("Expected no error setting up file but received %q", err)
+			}
+			defer os.Remove(file)
+
+			previous, err := snapshotFile(file)
+			if err != nil {
+				t.Fatalf("Expected no error snapshotting file but received %q", err)
+			}
+			if _, err := updateFile(file, "new", 0660); err != nil {
+				t.Fatalf("Expected no error updating file but received %q", err)
+			}
+
+			r := &Reconciler{HealthCheckTimeout: 10 * time.Millisecond}
+			restarts := 0
+			restart := func(context.Context) error {
+				restarts++
+				return nil
+			}
+			probes := []probe{func(context.Context) error { return tc.probeErr }}
+
+			err = r.restartAndVerify(context.Background(), "test", restart, probes, previous)
+			var rollbackErr *rollbackError
+			if rolledBack := errors.As(err, &rollbackErr); rolledBack != tc.expectRollback {
+				t.Fatalf("Expected rollback to be %v but it was %v instead (error was %v)", tc.expectRollback, rolledBack, err)
+			}
+			if restarts != tc.expectedRestarts {
+				t.Fatalf("Expected %d restarts but there were %d instead", tc.expectedRestarts, restarts)
+			}
+			b, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Expected no error reading file but received %q", err)
+			}
+			if string(b) != tc.expectedContents {
+				t.Fatalf("Expected file contents to be %q but they were %q instead", tc.expectedContents, string(b))
+			}
+		})
+	}
+}
+
+func TestIsRollback(t *testing.T) {
+	rollback := &rollbackError{service: "kubelite", err: errors.New("probe failed")}
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil", err: nil},
+		{name: "other", err: errors.New("failed")},
+		{name: "wrapped", err: fmt.Errorf("failed to restart kubelite service: %w", rollback), expected: true},
+		{name: "aggregate", err: kerrors.NewAggregate([]error{errors.New("failed"), fmt.Errorf("failed: %w", rollback)}), expected: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isRollback(tc.err); got != tc.expected {
+				t.Fatalf("Expected isRollback to be %v but it was %v", tc.expected, got)
+			}
+		})
+	}
+}
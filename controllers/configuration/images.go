@@ -0,0 +1,399 @@
+package configuration
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/hostfile"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// imageJobTimeout is how long a single image pull or archive import, including the download, may take.
+	imageJobTimeout = 30 * time.Minute
+)
+
+// imageDownloadClient downloads image archives.
+var imageDownloadClient = &http.Client{Timeout: imageJobTimeout}
+
+func mergeImages(base, overrides *microk8sv1alpha1.ImagesSpec) *microk8sv1alpha1.ImagesSpec {
+	if base == nil {
+		return overrides
+	}
+	if overrides == nil {
+		return base
+	}
+	return &microk8sv1alpha1.ImagesSpec{
+		Pull:     append(append([]string(nil), base.Pull...), overrides.Pull...),
+		Tarballs: append(append([]microk8sv1alpha1.ImageTarballSpec(nil), base.Tarballs...), overrides.Tarballs...),
+	}
+}
+
+// reconcileImages ensures that the configured images are present on the node and reports their status in the
+// local MicroK8sNode. Images are only pulled or imported if they are not present already. This is done in the
+// background, and the status reports the result of the last attempt.
+func (r *Reconciler) reconcileImages(ctx context.Context, images *microk8sv1alpha1.ImagesSpec) error {
+	if images == nil || r.observeOnly() {
+		return nil
+	}
+
+	var statuses []microk8sv1alpha1.ImageStatus
+	seen := make(map[string]struct{})
+	for _, image := range images.Pull {
+		image := image
+		key := "pull/" + image
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		statuses = append(statuses, r.images.status(ctx, key, image, func(ctx context.Context) microk8sv1alpha1.ImageStatus {
+			return r.ensureImagePulled(ctx, image)
+		}))
+	}
+	for _, tarball := range images.Tarballs {
+		tarball := tarball
+		key := "import/" + tarball.SHA256
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		source := tarball.URL
+		if source == "" {
+			source = tarball.HostPath
+		}
+		statuses = append(statuses, r.images.status(ctx, key, source, func(ctx context.Context) microk8sv1alpha1.ImageStatus {
+			return r.ensureImageImported(ctx, tarball)
+		}))
+	}
+	r.images.prune(seen)
+
+	var errs []error
+	for _, status := range statuses {
+		if status.Phase == microk8sv1alpha1.ImagePhaseFailed {
+			errs = append(errs, fmt.Errorf("image %s: %s", status.Image, status.Message))
+		}
+	}
+	if err := r.updateMicroK8sNodeStatus(ctx, func(status *microk8sv1alpha1.MicroK8sNodeStatus) bool {
+		if reflect.DeepEqual(status.Images, statuses) {
+			return false
+		}
+		status.Images = statuses
+		return true
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("failed to update image status: %w", err))
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// imageJobs runs image pulls and imports in the background, so that slow registries and downloads do not hold up
+// the reconcile pass. It keeps the result of the last run of each job, and sends an event when a result changes.
+type imageJobs struct {
+	mu      sync.Mutex
+	running map[string]struct{}
+	results map[string]microk8sv1alpha1.ImageStatus
+	events  chan<- event.GenericEvent
+}
+
+func newImageJobs(events chan<- event.GenericEvent) *imageJobs {
+	return &imageJobs{
+		running: make(map[string]struct{}),
+		results: make(map[string]microk8sv1alpha1.ImageStatus),
+		events:  events,
+	}
+}
+
+// status starts the job with key, unless it is already running, and returns the result of its last run. If the
+// job has not completed before, the status is Pending.
+func (j *imageJobs) status(ctx context.Context, key string, image string, run func(ctx context.Context) microk8sv1alpha1.ImageStatus) microk8sv1alpha1.ImageStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.running[key]; !ok {
+		j.running[key] = struct{}{}
+		// the job outlives the reconcile pass, so only the logger is kept from its context
+		jobCtx, cancel := context.WithTimeout(log.IntoContext(context.Background(), log.FromContext(ctx)), imageJobTimeout)
+		go func() {
+			defer cancel()
+			j.finish(key, run(jobCtx))
+		}()
+	}
+	if status, ok := j.results[key]; ok {
+		return status
+	}
+	return microk8sv1alpha1.ImageStatus{Image: image, Phase: microk8sv1alpha1.ImagePhasePending}
+}
+
+// finish records the result of a job, and requests a reconcile if it changed.
+func (j *imageJobs) finish(key string, status microk8sv1alpha1.ImageStatus) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.running, key)
+	if previous, ok := j.results[key]; ok && previous == status {
+		return
+	}
+	j.results[key] = status
+	select {
+	case j.events <- event.GenericEvent{Object: &microk8sv1alpha1.Configuration{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}:
+	default:
+		// a reconcile is already queued
+	}
+}
+
+// prune forgets the results of jobs that are no longer configured.
+func (j *imageJobs) prune(keys map[string]struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for key := range j.results {
+		if _, ok := keys[key]; !ok {
+			delete(j.results, key)
+		}
+	}
+}
+
+// ensureImagePulled pulls an image if it is not present.
+func (r *Reconciler) ensureImagePulled(ctx context.Context, image string) microk8sv1alpha1.ImageStatus {
+	log := log.FromContext(ctx).WithValues("image", image)
+	status := microk8sv1alpha1.ImageStatus{Image: image, Phase: microk8sv1alpha1.ImagePhaseFailed}
+
+	id, err := r.ImageID(ctx, image)
+	if err != nil {
+		status.Message = fmt.Sprintf("failed to get image status: %v", err)
+		return status
+	}
+	if id == "" {
+		if id, err = r.PullImage(ctx, image); err != nil {
+			log.Error(err, "failed to pull image")
+			r.events.Eventf(corev1.EventTypeWarning, reasonImageFailed, "Failed to pull image %s: %v", image, err)
+			status.Message = fmt.Sprintf("failed to pull image: %v", err)
+			return status
+		}
+		log.Info("pulled image")
+		r.events.Eventf(corev1.EventTypeNormal, reasonImagePulled, "Pulled image %s", image)
+	}
+	status.Phase = microk8sv1alpha1.ImagePhasePresent
+	status.ID = id
+	return status
+}
+
+// importMarker records an image archive that was imported, and the images it contains.
+type importMarker struct {
+	Source string   `json:"source"`
+	Images []string `json:"images"`
+}
+
+// ensureImageImported imports an image archive, unless an archive with the same checksum was imported before and
+// the images it contains are still present. Archives that do not name their images are not imported again.
+func (r *Reconciler) ensureImageImported(ctx context.Context, tarball microk8sv1alpha1.ImageTarballSpec) microk8sv1alpha1.ImageStatus {
+	source := tarball.URL
+	if source == "" {
+		source = tarball.HostPath
+	}
+	log := log.FromContext(ctx).WithValues("tarball", source)
+	status := microk8sv1alpha1.ImageStatus{Image: source, ID: tarball.SHA256, Phase: microk8sv1alpha1.ImagePhaseFailed}
+
+	marker := filepath.Join(r.ImagesDir, tarball.SHA256+".imported")
+	if b, err := os.ReadFile(marker); err == nil {
+		var imported importMarker
+		if err := json.Unmarshal(b, &imported); err == nil {
+			present, err := r.imagesPresent(ctx, imported.Images)
+			if err != nil {
+				status.Message = fmt.Sprintf("failed to get image status: %v", err)
+				return status
+			}
+			if present {
+				status.Phase = microk8sv1alpha1.ImagePhasePresent
+				return status
+			}
+			log.Info("images of imported archive are missing, importing again", "images", imported.Images)
+		}
+	}
+
+	images, err := r.importImageTarball(ctx, tarball)
+	if err != nil {
+		log.Error(err, "failed to import image archive")
+		r.events.Eventf(corev1.EventTypeWarning, reasonImageFailed, "Failed to import image archive %s: %v", source, err)
+		status.Message = err.Error()
+		return status
+	}
+	if b, err := json.Marshal(importMarker{Source: source, Images: images}); err != nil {
+		log.Error(err, "failed to record imported image archive")
+	} else if err := hostfile.WriteAtomic(marker, b, 0600); err != nil {
+		log.Error(err, "failed to record imported image archive")
+	}
+	log.Info("imported image archive")
+	r.events.Eventf(corev1.EventTypeNormal, reasonImageImported, "Imported image archive %s", source)
+	status.Phase = microk8sv1alpha1.ImagePhasePresent
+	return status
+}
+
+// imagesPresent returns true if all images are present on the node.
+func (r *Reconciler) imagesPresent(ctx context.Context, images []string) (bool, error) {
+	for _, image := range images {
+		id, err := r.ImageID(ctx, image)
+		if err != nil {
+			return false, err
+		}
+		if id == "" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// importImageTarball copies the archive to ImagesDir while verifying its checksum, and imports it.
+// It returns the images named in the archive.
+func (r *Reconciler) importImageTarball(ctx context.Context, tarball microk8sv1alpha1.ImageTarballSpec) ([]string, error) {
+	if r.ImportImage == nil {
+		return nil, fmt.Errorf("importing image archives is not supported on this node")
+	}
+
+	var src io.ReadCloser
+	switch {
+	case tarball.URL != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, tarball.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL: %w", err)
+		}
+		resp, err := imageDownloadClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to download: %s", resp.Status)
+		}
+		src = resp.Body
+	case tarball.HostPath != "":
+		path := tarball.HostPath
+		if r.LocalPath != nil {
+			var err error
+			if path, err = r.LocalPath(path); err != nil {
+				return nil, err
+			}
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open: %w", err)
+		}
+		src = f
+	default:
+		return nil, fmt.Errorf("one of hostPath or url must be specified")
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(r.ImagesDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create images directory: %w", err)
+	}
+	file := filepath.Join(r.ImagesDir, tarball.SHA256+".tar")
+	dst, err := os.Create(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(file)
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, h), src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy archive: %w", err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != tarball.SHA256 {
+		return nil, fmt.Errorf("checksum mismatch, expected %s but got %s", tarball.SHA256, sum)
+	}
+	images, err := archiveImages(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	if err := r.ImportImage(ctx, file); err != nil {
+		return nil, fmt.Errorf("failed to import: %w", err)
+	}
+	return images, nil
+}
+
+// archiveImages returns the names of the images in an image archive, in docker or OCI format. The archive may be
+// gzip compressed.
+func archiveImages(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var reader io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	var images []string
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch path.Clean(hdr.Name) {
+		case "manifest.json":
+			var manifest []struct {
+				RepoTags []string `json:"RepoTags"`
+			}
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest.json: %w", err)
+			}
+			for _, m := range manifest {
+				images = append(images, m.RepoTags...)
+			}
+		case "index.json":
+			var index struct {
+				Manifests []struct {
+					Annotations map[string]string `json:"annotations"`
+				} `json:"manifests"`
+			}
+			if err := json.NewDecoder(tr).Decode(&index); err != nil {
+				return nil, fmt.Errorf("invalid index.json: %w", err)
+			}
+			for _, m := range index.Manifests {
+				if name := m.Annotations["io.containerd.image.name"]; name != "" {
+					images = append(images, name)
+				}
+			}
+		}
+	}
+	sort.Strings(images)
+	unique := images[:0]
+	for i, image := range images {
+		if i == 0 || image != images[i-1] {
+			unique = append(unique, image)
+		}
+	}
+	return unique, nil
+}
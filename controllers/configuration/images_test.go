@@ -0,0 +1,191 @@
+package configuration
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// imageArchive returns a tar archive with the specified files.
+func imageArchive(t *testing.T, files map[string]string, compress bool) []byte {
+	var b bytes.Buffer
+	var w io.Writer = &b
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&b)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for name, contents := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}); err != nil {
+			t.Fatalf("Expected no error writing archive but received %q", err)
+		}
+		tw.Write([]byte(contents))
+	}
+	tw.Close()
+	if gz != nil {
+		gz.Close()
+	}
+	return b.Bytes()
+}
+
+func TestArchiveImages(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		archive  func(t *testing.T) []byte
+		expected []string
+	}{
+		{
+			name: "docker",
+			archive: func(t *testing.T) []byte {
+				return imageArchive(t, map[string]string{
+					"manifest.json": `[{"RepoTags":["nginx:latest","nginx:1.23"]},{"RepoTags":["nginx:latest"]}]`,
+				}, false)
+			},
+			expected: []string{"nginx:1.23", "nginx:latest"},
+		},
+		{
+			name: "oci-gzip",
+			archive: func(t *testing.T) []byte {
+				return imageArchive(t, map[string]string{
+					"index.json": `{"manifests":[{"annotations":{"io.containerd.image.name":"docker.io/library/nginx:latest"}}]}`,
+				}, true)
+			},
+			expected: []string{"docker.io/library/nginx:latest"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "image.tar")
+			if err := os.WriteFile(file, tc.archive(t), 0600); err != nil {
+				t.Fatalf("Expected no error setting up archive but received %q", err)
+			}
+			images, err := archiveImages(file)
+			if err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+			if !reflect.DeepEqual(images, tc.expected) {
+				t.Fatalf("Expected images %v but got %v", tc.expected, images)
+			}
+		})
+	}
+}
+
+func TestEnsureImageImported(t *testing.T) {
+	dir := t.TempDir()
+	archive := imageArchive(t, map[string]string{"manifest.json": `[{"RepoTags":["nginx:latest"]}]`}, false)
+	hostPath := filepath.Join(dir, "nginx.tar")
+	if err := os.WriteFile(hostPath, archive, 0600); err != nil {
+		t.Fatalf("Expected no error setting up archive but received %q", err)
+	}
+	sum := sha256.Sum256(archive)
+	tarball := microk8sv1alpha1.ImageTarballSpec{HostPath: hostPath, SHA256: hex.EncodeToString(sum[:])}
+
+	present := map[string]bool{}
+	imports := 0
+	r := &Reconciler{
+		ImagesDir: filepath.Join(dir, "images"),
+		ImageID: func(ctx context.Context, image string) (string, error) {
+			if present[image] {
+				return "sha256:1234", nil
+			}
+			return "", nil
+		},
+		ImportImage: func(ctx context.Context, file string) error {
+			imports++
+			present["nginx:latest"] = true
+			return nil
+		},
+	}
+
+	for i, tc := range []struct {
+		removeImage     bool
+		expectedImports int
+	}{
+		{expectedImports: 1},
+		{expectedImports: 1},
+		{removeImage: true, expectedImports: 2},
+	} {
+		if tc.removeImage {
+			delete(present, "nginx:latest")
+		}
+		status := r.ensureImageImported(context.Background(), tarball)
+		if status.Phase != microk8sv1alpha1.ImagePhasePresent {
+			t.Fatalf("Expected image to be present on pass %d but got %q: %s", i, status.Phase, status.Message)
+		}
+		if imports != tc.expectedImports {
+			t.Fatalf("Expected %d imports after pass %d but got %d", tc.expectedImports, i, imports)
+		}
+	}
+}
+
+func TestReconcileImagesInBackground(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = microk8sv1alpha1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&microk8sv1alpha1.MicroK8sNode{ObjectMeta: metav1.ObjectMeta{Name: "node"}}).Build()
+
+	events := make(chan event.GenericEvent, 1)
+	pull := make(chan struct{})
+	pulled := false
+	r := &Reconciler{
+		Client: c,
+		Node:   "node",
+		images: newImageJobs(events),
+		ImageID: func(ctx context.Context, image string) (string, error) {
+			if pulled {
+				return "sha256:1234", nil
+			}
+			return "", nil
+		},
+		PullImage: func(ctx context.Context, image string) (string, error) {
+			<-pull
+			pulled = true
+			return "sha256:1234", nil
+		},
+	}
+	images := &microk8sv1alpha1.ImagesSpec{Pull: []string{"nginx:latest"}}
+
+	imageStatus := func() microk8sv1alpha1.ImageStatus {
+		if err := r.reconcileImages(context.Background(), images); err != nil {
+			t.Fatalf("Expected no error but received %q", err)
+		}
+		node := &microk8sv1alpha1.MicroK8sNode{}
+		if err := c.Get(context.Background(), types.NamespacedName{Name: "node"}, node); err != nil {
+			t.Fatalf("Expected no error but received %q", err)
+		}
+		if len(node.Status.Images) != 1 {
+			t.Fatalf("Expected the status of 1 image but got %v", node.Status.Images)
+		}
+		return node.Status.Images[0]
+	}
+
+	if status := imageStatus(); status.Phase != microk8sv1alpha1.ImagePhasePending {
+		t.Fatalf("Expected image to be pending while it is pulled but got %q", status.Phase)
+	}
+	close(pull)
+	select {
+	case <-events:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected a reconcile to be requested once the image is pulled")
+	}
+	if status := imageStatus(); status.Phase != microk8sv1alpha1.ImagePhasePresent || status.ID != "sha256:1234" {
+		t.Fatalf("Expected image to be present but got %+v", status)
+	}
+}
@@ -0,0 +1,126 @@
+package configuration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	kubeletConfigAPIVersion = "kubelet.config.k8s.io/v1beta1"
+	kubeletConfigKind       = "KubeletConfiguration"
+)
+
+// mergeObjects merges two JSON objects. Nested objects are merged key by key, any other value in overrides
+// replaces the value in base.
+func mergeObjects(base, overrides map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(base)+len(overrides))
+	for key, val := range base {
+		m[key] = val
+	}
+	for key, val := range overrides {
+		baseObj, baseIsObj := m[key].(map[string]interface{})
+		obj, isObj := val.(map[string]interface{})
+		if baseIsObj && isObj {
+			m[key] = mergeObjects(baseObj, obj)
+		} else {
+			m[key] = val
+		}
+	}
+	return m
+}
+
+// decodeObject decodes a raw JSON object. A nil or empty raw decodes to a nil map.
+func decodeObject(raw *runtime.RawExtension) (map[string]interface{}, error) {
+	if raw == nil || len(raw.Raw) == 0 {
+		return nil, nil
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw.Raw, &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// mergeRawObjects merges two raw JSON objects with mergeObjects. If either cannot be decoded, overrides is used.
+func mergeRawObjects(base, overrides *runtime.RawExtension) *runtime.RawExtension {
+	if base == nil {
+		return overrides
+	}
+	if overrides == nil {
+		return base
+	}
+	baseObj, err := decodeObject(base)
+	if err != nil {
+		return overrides
+	}
+	obj, err := decodeObject(overrides)
+	if err != nil {
+		return overrides
+	}
+	b, err := json.Marshal(mergeObjects(baseObj, obj))
+	if err != nil {
+		return overrides
+	}
+	return &runtime.RawExtension{Raw: b}
+}
+
+// renderKubeletConfig renders the kubelet config file from a partial KubeletConfiguration.
+func renderKubeletConfig(config map[string]interface{}) (string, error) {
+	config = mergeObjects(config, map[string]interface{}{
+		"apiVersion": kubeletConfigAPIVersion,
+		"kind":       kubeletConfigKind,
+	})
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// reconcileKubeletConfig writes the kubelet config file and ensures kubelet is started with --config.
+// Nothing is changed if no KubeletConfiguration is specified.
+func (r *Reconciler) reconcileKubeletConfig(ctx context.Context, raw *runtime.RawExtension) error {
+	config, err := decodeObject(raw)
+	if err != nil {
+		return fmt.Errorf("invalid kubelet configuration: %w", err)
+	}
+	if config == nil {
+		return nil
+	}
+	log := log.FromContext(ctx)
+	contents, err := renderKubeletConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to render kubelet config file: %w", err)
+	}
+
+	previousConfig, err := snapshotFile(r.KubeletConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to read kubelet config file: %w", err)
+	}
+	previousArgs, err := snapshotFile(r.KubeletArgsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read kubelet args file: %w", err)
+	}
+	configUpdated, err := r.updateHostFile(r.KubeletConfigFile, contents, 0660)
+	if err != nil {
+		return fmt.Errorf("failed to update kubelet config file: %w", err)
+	}
+	argsUpdated, err := updateServiceArguments(r.updateHostFile, r.KubeletArgsFile, map[string]*string{"--config": r.argumentPath(r.KubeletConfigFile)})
+	if err != nil {
+		return fmt.Errorf("failed to update kubelet args file: %w", err)
+	}
+	if !configUpdated && !argsUpdated {
+		log.Info("kubelet config file up to date")
+		return nil
+	}
+	log.Info("updated kubelet config file")
+	r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated kubelet config file %s", r.KubeletConfigFile)
+	r.requestRestart(restartKubelite, "kubelet configuration", []probe{r.probeKubelet}, previousConfig, previousArgs)
+	return nil
+}
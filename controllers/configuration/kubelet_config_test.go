@@ -0,0 +1,26 @@
+package configuration
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestMergeRawObjects(t *testing.T) {
+	base := &runtime.RawExtension{Raw: []byte(`{"evictionHard":{"memory.available":"100Mi","nodefs.available":"10%"},"maxPods":110}`)}
+	overrides := &runtime.RawExtension{Raw: []byte(`{"evictionHard":{"memory.available":"500Mi"},"maxPods":50}`)}
+
+	var merged map[string]interface{}
+	if err := json.Unmarshal(mergeRawObjects(base, overrides).Raw, &merged); err != nil {
+		t.Fatalf("Expected no error decoding merged object but received %q", err)
+	}
+	expected := map[string]interface{}{
+		"evictionHard": map[string]interface{}{"memory.available": "500Mi", "nodefs.available": "10%"},
+		"maxPods":      float64(50),
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Expected merged object %v but got %v", expected, merged)
+	}
+}
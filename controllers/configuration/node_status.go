@@ -0,0 +1,72 @@
+package configuration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// updateMicroK8sNodeStatus updates the status of the local MicroK8sNode. update must return false if nothing
+// changed, in which case the status is not written.
+func (r *Reconciler) updateMicroK8sNodeStatus(ctx context.Context, update func(status *microk8sv1alpha1.MicroK8sNodeStatus) bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node := &microk8sv1alpha1.MicroK8sNode{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: r.Node}, node); err != nil {
+			return err
+		}
+		if !update(&node.Status) {
+			return nil
+		}
+		return r.Client.Status().Update(ctx, node)
+	})
+}
+
+// configHash returns a short hash of a configuration spec.
+func configHash(spec microk8sv1alpha1.ConfigurationSpec) (string, error) {
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:16], nil
+}
+
+// reportAppliedConfig records the hash of the applied configuration in the status of the local MicroK8sNode.
+func (r *Reconciler) reportAppliedConfig(ctx context.Context, hash string) error {
+	return r.updateMicroK8sNodeStatus(ctx, func(status *microk8sv1alpha1.MicroK8sNodeStatus) bool {
+		if status.AppliedConfigHash == hash && status.RolledBackConfigHash == "" {
+			return false
+		}
+		status.AppliedConfigHash = hash
+		status.RolledBackConfigHash = ""
+		return true
+	})
+}
+
+// reportRolledBackConfig records the hash of a configuration that was rolled back in the status of the local
+// MicroK8sNode, so that it is not applied again until it changes.
+func (r *Reconciler) reportRolledBackConfig(ctx context.Context, hash string) error {
+	return r.updateMicroK8sNodeStatus(ctx, func(status *microk8sv1alpha1.MicroK8sNodeStatus) bool {
+		if status.RolledBackConfigHash == hash {
+			return false
+		}
+		status.RolledBackConfigHash = hash
+		return true
+	})
+}
+
+// rolledBackConfigHash returns the hash of the configuration that was last rolled back on the local node, if any.
+func (r *Reconciler) rolledBackConfigHash(ctx context.Context) (string, error) {
+	node := &microk8sv1alpha1.MicroK8sNode{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: r.Node}, node); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	return node.Status.RolledBackConfigHash, nil
+}
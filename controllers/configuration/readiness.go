@@ -0,0 +1,203 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// ReadinessGateTaint keeps the UnconfiguredTaintKey NoSchedule taint on new nodes until they are configured.
+	ReadinessGateTaint = "taint"
+	// ReadinessGateCondition keeps the ConditionConfigured Node condition False on new nodes until they are configured.
+	// The scheduler ignores the condition, so it is informational only, e.g. for external tooling.
+	ReadinessGateCondition = "condition"
+
+	// UnconfiguredTaintKey is the taint set on nodes that are not configured yet.
+	UnconfiguredTaintKey = "microk8s.io/unconfigured"
+	// ConfiguredAnnotation is set on nodes once the readiness gate has been released, so that they are never gated again.
+	ConfiguredAnnotation = "microk8s.io/configured"
+	// ConditionConfigured is the Node condition that is True once the configuration has been applied on the node.
+	ConditionConfigured corev1.NodeConditionType = "MicroK8sConfigured"
+)
+
+// isNodeConfigured returns true if the node should not be gated. This is the case for nodes that were configured
+// before, and for nodes that joined the cluster longer than ReadinessGateWindow ago, e.g. when the readiness gate
+// is first enabled on an existing cluster.
+func (r *Reconciler) isNodeConfigured(node *corev1.Node) bool {
+	if _, ok := node.Annotations[ConfiguredAnnotation]; ok {
+		return true
+	}
+	return r.ReadinessGateWindow > 0 && time.Since(node.CreationTimestamp.Time) > r.ReadinessGateWindow
+}
+
+// withRegisterTaint adds the unconfigured taint to the kubelet --register-with-taints argument of the spec. The node
+// agent only runs once the node has registered, so this closes the gap for nodes that register again, e.g. after
+// they are removed and join again. If the argument is removed in the spec, it is left alone.
+func withRegisterTaint(spec microk8sv1alpha1.ConfigurationSpec) microk8sv1alpha1.ConfigurationSpec {
+	existing, ok := spec.ExtraKubeletArgs["--register-with-taints"]
+	if ok && existing == nil {
+		return spec
+	}
+	var taints []string
+	if existing != nil && *existing != "" {
+		taints = strings.Split(*existing, ",")
+	}
+	for _, taint := range taints {
+		if strings.HasPrefix(taint, UnconfiguredTaintKey+":") || strings.HasPrefix(taint, UnconfiguredTaintKey+"=") {
+			return spec
+		}
+	}
+	args := make(map[string]*string, len(spec.ExtraKubeletArgs)+1)
+	for key, val := range spec.ExtraKubeletArgs {
+		args[key] = val
+	}
+	value := strings.Join(append(taints, UnconfiguredTaintKey+":"+string(corev1.TaintEffectNoSchedule)), ",")
+	args["--register-with-taints"] = &value
+	spec.ExtraKubeletArgs = args
+	return spec
+}
+
+// holdReadinessGate sets the readiness gate on the node if it has never been configured.
+func (r *Reconciler) holdReadinessGate(ctx context.Context) error {
+	if r.ReadinessGate == "" || r.observeOnly() {
+		return nil
+	}
+	return r.setReadinessGate(ctx, true)
+}
+
+// releaseReadinessGate clears the readiness gate of the node, and records how long after joining the cluster the
+// node was configured.
+func (r *Reconciler) releaseReadinessGate(ctx context.Context) error {
+	if r.ReadinessGate == "" {
+		return nil
+	}
+	return r.setReadinessGate(ctx, false)
+}
+
+// setReadinessGate sets or clears the readiness gate of the node. Clearing the gate also marks the node as configured.
+func (r *Reconciler) setReadinessGate(ctx context.Context, hold bool) error {
+	var changed bool
+	var joined time.Time
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node := &corev1.Node{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: r.Node}, node); err != nil {
+			return err
+		}
+		joined = node.CreationTimestamp.Time
+		if hold && r.isNodeConfigured(node) {
+			changed = false
+			return nil
+		}
+		if _, ok := node.Annotations[ConfiguredAnnotation]; !hold && !ok {
+			annotate := client.MergeFrom(node.DeepCopy())
+			metav1.SetMetaDataAnnotation(&node.ObjectMeta, ConfiguredAnnotation, "true")
+			if err := r.Client.Patch(ctx, node, annotate); err != nil {
+				return err
+			}
+		}
+		patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+
+		switch r.ReadinessGate {
+		case ReadinessGateTaint:
+			if changed = setUnconfiguredTaint(node, hold); changed {
+				return r.Client.Patch(ctx, node, patch)
+			}
+		case ReadinessGateCondition:
+			if changed = setConfiguredCondition(node, !hold); changed {
+				return r.Client.Status().Patch(ctx, node, patch)
+			}
+		default:
+			return fmt.Errorf("unknown readiness gate %q", r.ReadinessGate)
+		}
+		return nil
+	})
+	if err != nil || !changed {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	log := log.FromContext(ctx)
+	if hold {
+		log.Info("node is not configured yet, holding readiness gate", "gate", r.ReadinessGate)
+		return nil
+	}
+
+	configuredAfter := time.Since(joined).Round(time.Second)
+	log.Info("node configured, released readiness gate", "gate", r.ReadinessGate, "configuredAfter", configuredAfter)
+	r.events.Eventf(corev1.EventTypeNormal, reasonNodeConfigured, "Node %s was configured %s after joining the cluster", r.Node, configuredAfter)
+	metrics.NodeConfiguredAfter.WithLabelValues(r.Node).Set(configuredAfter.Seconds())
+	return r.updateMicroK8sNodeStatus(ctx, func(status *microk8sv1alpha1.MicroK8sNodeStatus) bool {
+		status.ConfiguredAfter = &metav1.Duration{Duration: configuredAfter}
+		return true
+	})
+}
+
+// setUnconfiguredTaint adds or removes the unconfigured taint. It returns true if the taints changed.
+func setUnconfiguredTaint(node *corev1.Node, present bool) bool {
+	for i, taint := range node.Spec.Taints {
+		if taint.Key != UnconfiguredTaintKey {
+			continue
+		}
+		if present {
+			return false
+		}
+		node.Spec.Taints = append(node.Spec.Taints[:i], node.Spec.Taints[i+1:]...)
+		return true
+	}
+	if !present {
+		return false
+	}
+	node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{Key: UnconfiguredTaintKey, Effect: corev1.TaintEffectNoSchedule})
+	return true
+}
+
+// setConfiguredCondition sets the configured Node condition. It returns true if the condition changed.
+func setConfiguredCondition(node *corev1.Node, configured bool) bool {
+	condition := corev1.NodeCondition{
+		Type:    ConditionConfigured,
+		Status:  corev1.ConditionFalse,
+		Reason:  "ConfigurationPending",
+		Message: "the MicroK8s configuration has not been applied on the node yet",
+	}
+	if configured {
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = "ConfigurationApplied"
+		condition.Message = "the MicroK8s configuration has been applied on the node"
+	}
+
+	now := metav1.Now()
+	for i, existing := range node.Status.Conditions {
+		if existing.Type != ConditionConfigured {
+			continue
+		}
+		if existing.Status == condition.Status {
+			return false
+		}
+		condition.LastHeartbeatTime = now
+		condition.LastTransitionTime = now
+		node.Status.Conditions[i] = condition
+		return true
+	}
+	if configured {
+		// the gate was never set, e.g. because the node was configured before the gate was enabled
+		return false
+	}
+	condition.LastHeartbeatTime = now
+	condition.LastTransitionTime = now
+	node.Status.Conditions = append(node.Status.Conditions, condition)
+	return true
+}
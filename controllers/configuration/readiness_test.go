@@ -0,0 +1,103 @@
+package configuration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSetUnconfiguredTaint(t *testing.T) {
+	node := &corev1.Node{Spec: corev1.NodeSpec{Taints: []corev1.Taint{{Key: "other", Effect: corev1.TaintEffectNoExecute}}}}
+
+	if !setUnconfiguredTaint(node, true) || len(node.Spec.Taints) != 2 {
+		t.Fatalf("Expected taint to be added but got %v", node.Spec.Taints)
+	}
+	if setUnconfiguredTaint(node, true) {
+		t.Fatal("Expected taint to be present already")
+	}
+	if !setUnconfiguredTaint(node, false) || len(node.Spec.Taints) != 1 || node.Spec.Taints[0].Key != "other" {
+		t.Fatalf("Expected taint to be removed but got %v", node.Spec.Taints)
+	}
+}
+
+func TestSetConfiguredCondition(t *testing.T) {
+	node := &corev1.Node{}
+	if setConfiguredCondition(node, true) {
+		t.Fatal("Expected no condition to be added for a node that was never gated")
+	}
+	if !setConfiguredCondition(node, false) || node.Status.Conditions[0].Status != corev1.ConditionFalse {
+		t.Fatalf("Expected condition to be False but got %v", node.Status.Conditions)
+	}
+	if !setConfiguredCondition(node, true) || node.Status.Conditions[0].Status != corev1.ConditionTrue {
+		t.Fatalf("Expected condition to be True but got %v", node.Status.Conditions)
+	}
+}
+
+func TestHoldReadinessGate(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		age         time.Duration
+		annotations map[string]string
+		window      time.Duration
+		expectTaint bool
+	}{
+		{name: "new", age: time.Minute, window: 30 * time.Minute, expectTaint: true},
+		{name: "existing", age: time.Hour, window: 30 * time.Minute},
+		{name: "configured", age: time.Minute, window: 30 * time.Minute, annotations: map[string]string{ConfiguredAnnotation: "true"}},
+		{name: "no-window", age: time.Hour, expectTaint: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = clientgoscheme.AddToScheme(scheme)
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:              "node",
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-tc.age)),
+				Annotations:       tc.annotations,
+			}}
+			r := &Reconciler{
+				Client:              fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build(),
+				Node:                "node",
+				ReadinessGate:       ReadinessGateTaint,
+				ReadinessGateWindow: tc.window,
+			}
+			if err := r.holdReadinessGate(context.Background()); err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+			if err := r.Client.Get(context.Background(), types.NamespacedName{Name: "node"}, node); err != nil {
+				t.Fatalf("Expected no error getting node but received %q", err)
+			}
+			if tainted := len(node.Spec.Taints) == 1; tainted != tc.expectTaint {
+				t.Fatalf("Expected taint %v but got taints %v", tc.expectTaint, node.Spec.Taints)
+			}
+		})
+	}
+}
+
+func TestWithRegisterTaint(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		args     map[string]*string
+		expected *string
+	}{
+		{name: "not set", expected: ptr("microk8s.io/unconfigured:NoSchedule")},
+		{name: "other taints", args: map[string]*string{"--register-with-taints": ptr("gpu=true:NoSchedule")}, expected: ptr("gpu=true:NoSchedule,microk8s.io/unconfigured:NoSchedule")},
+		{name: "already set", args: map[string]*string{"--register-with-taints": ptr("microk8s.io/unconfigured:NoExecute")}, expected: ptr("microk8s.io/unconfigured:NoExecute")},
+		{name: "removed", args: map[string]*string{"--register-with-taints": nil}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := withRegisterTaint(microk8sv1alpha1.ConfigurationSpec{ExtraKubeletArgs: tc.args})
+			v := spec.ExtraKubeletArgs["--register-with-taints"]
+			if (v == nil) != (tc.expected == nil) || (v != nil && *v != *tc.expected) {
+				t.Fatalf("Expected --register-with-taints %v but got %v", tc.expected, v)
+			}
+		})
+	}
+}
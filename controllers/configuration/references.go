@@ -0,0 +1,35 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// getConfigMapKey returns the value of a ConfigMap key.
+func (r *Reconciler) getConfigMapKey(ctx context.Context, ref microk8sv1alpha1.KeyReference) (string, error) {
+	cm, err := r.Clientset.CoreV1().ConfigMaps(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get configmap %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	value, ok := cm.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("configmap %s/%s has no key %q", ref.Namespace, ref.Name, ref.Key)
+	}
+	return value, nil
+}
+
+// getSecretKey returns the value of a Secret key.
+func (r *Reconciler) getSecretKey(ctx context.Context, ref microk8sv1alpha1.KeyReference) ([]byte, error) {
+	secret, err := r.Clientset.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no key %q", ref.Namespace, ref.Name, ref.Key)
+	}
+	return value, nil
+}
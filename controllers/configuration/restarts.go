@@ -0,0 +1,106 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/neoaggelos/microk8s-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Restarts that can be requested by the reconcile sections, one for each affected snap service.
+const (
+	restartContainerd   = "containerd"
+	restartCertificates = "certificates"
+	restartKubelite     = "kubelite"
+)
+
+// restartOrder is the order in which restarts are performed. containerd is restarted first, since kubelite
+// depends on it. Refreshing the certificates restarts kubelite through the configure hook, so a kubelite
+// restart is not needed when the certificates are refreshed.
+var restartOrder = []string{restartContainerd, restartCertificates, restartKubelite}
+
+// pendingRestart is a restart requested by one or more sections during a reconcile pass.
+type pendingRestart struct {
+	reasons  []string
+	probes   []probe
+	previous []fileState
+}
+
+// restartPlan collects the restarts needed during a reconcile pass, so that each one is performed only once.
+type restartPlan struct {
+	pending map[string]*pendingRestart
+}
+
+// withRestartPlan returns a copy of the reconciler that collects restarts, which are performed by runRestarts.
+func (r *Reconciler) withRestartPlan() *Reconciler {
+	withRestarts := *r
+	withRestarts.restarts = &restartPlan{pending: make(map[string]*pendingRestart)}
+	return &withRestarts
+}
+
+// requestRestart schedules a restart at the end of the reconcile pass. reason describes the change that needs
+// the restart, probes verify the services afterwards, and previous is the state of the files to roll back to.
+func (r *Reconciler) requestRestart(name string, reason string, probes []probe, previous ...fileState) {
+	p, ok := r.restarts.pending[name]
+	if !ok {
+		p = &pendingRestart{}
+		r.restarts.pending[name] = p
+	}
+	p.reasons = append(p.reasons, reason)
+	p.probes = append(p.probes, probes...)
+	p.previous = append(p.previous, previous...)
+}
+
+// runRestarts performs the requested restarts in dependency order.
+func (r *Reconciler) runRestarts(ctx context.Context) error {
+	pending := r.restarts.pending
+	if cert, ok := pending[restartCertificates]; ok {
+		if kubelite, ok := pending[restartKubelite]; ok {
+			cert.reasons = append(cert.reasons, kubelite.reasons...)
+			cert.probes = append(cert.probes, kubelite.probes...)
+			cert.previous = append(cert.previous, kubelite.previous...)
+			delete(pending, restartKubelite)
+		}
+	}
+
+	var errs []error
+	for _, name := range restartOrder {
+		p, ok := pending[name]
+		if !ok {
+			continue
+		}
+		log := log.FromContext(ctx).WithValues("restart", name, "reasons", p.reasons)
+		reasons := strings.Join(p.reasons, ", ")
+
+		switch name {
+		case restartContainerd:
+			if err := r.restartAndVerify(ctx, "containerd", r.RestartContainerd, p.probes, p.previous...); err != nil {
+				errs = append(errs, fmt.Errorf("failed to restart containerd service: %w", err))
+				continue
+			}
+			log.Info("restarted containerd service")
+			r.events.Eventf(corev1.EventTypeNormal, reasonServiceRestarted, "Restarted containerd service to apply %s", reasons)
+		case restartKubelite:
+			if err := r.restartAndVerify(ctx, "kubelite", r.RestartKubelite, p.probes, p.previous...); err != nil {
+				errs = append(errs, fmt.Errorf("failed to restart kubelite service: %w", err))
+				continue
+			}
+			log.Info("restarted kubelite service")
+			r.events.Eventf(corev1.EventTypeNormal, reasonServiceRestarted, "Restarted kubelite service to apply %s", reasons)
+		case restartCertificates:
+			err := r.restartAndVerify(ctx, "certificates", r.RefreshCertificates, p.probes, p.previous...)
+			metrics.CertificateRefreshes.WithLabelValues(metrics.Outcome(err)).Inc()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to refresh the cluster certificates: %w", err))
+				continue
+			}
+			log.Info("refreshed certificates")
+			r.events.Eventf(corev1.EventTypeNormal, reasonCertificatesRefreshed, "Refreshed server certificates to apply %s", reasons)
+		}
+	}
+	return kerrors.NewAggregate(errs)
+}
@@ -0,0 +1,55 @@
+package configuration
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRunRestarts(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		requests []string
+		expected []string
+	}{
+		{
+			name:     "kubelite once",
+			requests: []string{restartKubelite, restartKubelite},
+			expected: []string{restartKubelite},
+		},
+		{
+			name:     "certificates restart kubelite",
+			requests: []string{restartKubelite, restartCertificates},
+			expected: []string{restartCertificates},
+		},
+		{
+			name:     "containerd first",
+			requests: []string{restartKubelite, restartContainerd, restartContainerd},
+			expected: []string{restartContainerd, restartKubelite},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var restarts []string
+			restart := func(name string) func(context.Context) error {
+				return func(context.Context) error {
+					restarts = append(restarts, name)
+					return nil
+				}
+			}
+			r := (&Reconciler{
+				RestartContainerd:   restart(restartContainerd),
+				RestartKubelite:     restart(restartKubelite),
+				RefreshCertificates: restart(restartCertificates),
+			}).withRestartPlan()
+			for _, name := range tc.requests {
+				r.requestRestart(name, "test", nil)
+			}
+			if err := r.runRestarts(context.Background()); err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+			if !reflect.DeepEqual(restarts, tc.expected) {
+				t.Fatalf("Expected restarts %v but got %v", tc.expected, restarts)
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"text/template"
 
+	corev1 "k8s.io/api/core/v1"
+
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -60,7 +62,11 @@ func (r *Reconciler) reconcileSANs(ctx context.Context, ips, sans []string) erro
 		return fmt.Errorf("failed to render csr.conf.template: %w", err)
 	}
 
-	updated, err := updateFile(r.CSRConfFile, b.String(), 0660)
+	previous, err := snapshotFile(r.CSRConfFile)
+	if err != nil {
+		return fmt.Errorf("failed to read csr.conf.template: %w", err)
+	}
+	updated, err := r.updateHostFile(r.CSRConfFile, b.String(), 0660)
 	if err != nil {
 		return fmt.Errorf("failed to write csr.conf.template: %w", err)
 	}
@@ -69,8 +75,7 @@ func (r *Reconciler) reconcileSANs(ctx context.Context, ips, sans []string) erro
 		return nil
 	}
 	log.FromContext(ctx).Info("updated csr.conf file")
-	if err := r.RefreshCertificates(ctx); err != nil {
-		return fmt.Errorf("failed to refresh the cluster certificates: %w", err)
-	}
+	r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated certificate request template %s", r.CSRConfFile)
+	r.requestRestart(restartCertificates, "certificate SANs", []probe{r.probeKubeAPIServer, r.probeKubelet}, previous)
 	return nil
 }
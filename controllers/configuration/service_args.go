@@ -6,14 +6,39 @@ import (
 	"os"
 	"strings"
 
+	corev1 "k8s.io/api/core/v1"
+
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+// readServiceArguments returns the arguments in the arguments file of a service.
+func readServiceArguments(argumentsFile string) (map[string]string, error) {
+	arguments, err := os.ReadFile(argumentsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read arguments file: %w", err)
+	}
+	result := make(map[string]string)
+	for _, line := range strings.Split(string(arguments), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// handle "--argument value" and "--argument=value" variants
+		key, value := line, ""
+		if idx := strings.IndexAny(line, "= "); idx >= 0 {
+			key, value = line[:idx], strings.TrimSpace(line[idx+1:])
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
 // updateServiceArguments updates the arguments file for a service.
 // updateMap is a map of key-value pairs. It will replace the argument with the new value (or just append).
 // if a value is nil, then the argument is removed if present.
+// update is used to write the arguments file.
 // returns true/false whether the service file was updated as well as the error that occured, or nil.
-func updateServiceArguments(argumentsFile string, updateMap map[string]*string) (bool, error) {
+func updateServiceArguments(update updateFunc, argumentsFile string, updateMap map[string]*string) (bool, error) {
 	// If no updates are requested, exit early
 	if len(updateMap) == 0 {
 		return false, nil
@@ -56,7 +81,7 @@ func updateServiceArguments(argumentsFile string, updateMap map[string]*string)
 		}
 	}
 
-	updated, err := updateFile(argumentsFile, strings.Join(newArguments, "\n")+"\n", 0660)
+	updated, err := update(argumentsFile, strings.Join(newArguments, "\n")+"\n", 0660)
 	if err != nil {
 		return updated, fmt.Errorf("failed to update arguments file: %w", err)
 	}
@@ -68,7 +93,11 @@ func (r *Reconciler) reconcileKubeletArgs(ctx context.Context, args map[string]*
 		return nil
 	}
 	log := log.FromContext(ctx)
-	updated, err := updateServiceArguments(r.KubeletArgsFile, args)
+	previous, err := snapshotFile(r.KubeletArgsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read kubelet args file: %w", err)
+	}
+	updated, err := updateServiceArguments(r.updateHostFile, r.KubeletArgsFile, args)
 	if err != nil {
 		return fmt.Errorf("failed to update kubelet args file: %w", err)
 	}
@@ -77,11 +106,33 @@ func (r *Reconciler) reconcileKubeletArgs(ctx context.Context, args map[string]*
 		return nil
 	}
 	log.Info("updated kubelet arguments file")
+	r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated kubelet arguments file %s", r.KubeletArgsFile)
+
+	r.requestRestart(restartKubelite, "kubelet arguments", []probe{r.probeKubelet}, previous)
+	return nil
+}
 
-	if err := r.RestartKubelet(ctx); err != nil {
-		return fmt.Errorf("failed to restart kubelet: %w", err)
+// resetKubeAPIServerArguments sets the kube-apiserver arguments of a configuration section that is not set, e.g. to
+// remove the arguments that were managed by the operator, and requests a kubelite restart if anything changed.
+// Arguments that are set in extraArgs are left alone.
+func (r *Reconciler) resetKubeAPIServerArguments(ctx context.Context, section string, args map[string]*string, extraArgs map[string]*string) error {
+	for key := range extraArgs {
+		delete(args, key)
 	}
-	log.Info("restarted kubelet")
+	previous, err := snapshotFile(r.KubeAPIServerArgsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read kube-apiserver args file: %w", err)
+	}
+	updated, err := updateServiceArguments(r.updateHostFile, r.KubeAPIServerArgsFile, args)
+	if err != nil {
+		return fmt.Errorf("failed to update kube-apiserver args file: %w", err)
+	}
+	if !updated {
+		return nil
+	}
+	log.FromContext(ctx).Info("removed kube-apiserver arguments", "section", section)
+	r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Removed kube-apiserver %s arguments", section)
+	r.requestRestart(restartKubelite, section+" removed", []probe{r.probeKubeAPIServer}, previous)
 	return nil
 }
 
@@ -90,7 +141,11 @@ func (r *Reconciler) reconcileKubeAPIServerArgs(ctx context.Context, args map[st
 		return nil
 	}
 	log := log.FromContext(ctx)
-	updated, err := updateServiceArguments(r.KubeAPIServerArgsFile, args)
+	previous, err := snapshotFile(r.KubeAPIServerArgsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read kube-apiserver args file: %w", err)
+	}
+	updated, err := updateServiceArguments(r.updateHostFile, r.KubeAPIServerArgsFile, args)
 	if err != nil {
 		return fmt.Errorf("failed to update kube-apiserver args file: %w", err)
 	}
@@ -99,10 +154,8 @@ func (r *Reconciler) reconcileKubeAPIServerArgs(ctx context.Context, args map[st
 		return nil
 	}
 	log.Info("updated kube-apiserver arguments file")
+	r.events.Eventf(corev1.EventTypeNormal, reasonFileUpdated, "Updated kube-apiserver arguments file %s", r.KubeAPIServerArgsFile)
 
-	if err := r.RestartKubeAPIServer(ctx); err != nil {
-		return fmt.Errorf("failed to restart kube-apiserver: %w", err)
-	}
-	log.Info("restarted kube-apiserver")
+	r.requestRestart(restartKubelite, "kube-apiserver arguments", []probe{r.probeKubeAPIServer}, previous)
 	return nil
 }
@@ -3,8 +3,16 @@ package configuration
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/neoaggelos/microk8s-operator/pkg/nodeutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func ptr(s string) *string {
@@ -90,7 +98,7 @@ func TestUpdateServiceArguments(t *testing.T) {
 				t.Fatalf("Expected no error setting up arguments file but received %q", err)
 			}
 			defer os.Remove(file)
-			updated, err := updateServiceArguments(file, tc.update)
+			updated, err := updateServiceArguments(updateFile, file, tc.update)
 			if err != nil {
 				t.Fatalf("Expected no error updating arguments file but received %q", err)
 			}
@@ -115,3 +123,38 @@ func TestUpdateServiceArguments(t *testing.T) {
 		})
 	}
 }
+
+// controlPlaneReconciler returns a reconciler for a control plane node whose kube-apiserver arguments file has the
+// specified contents.
+func controlPlaneReconciler(t *testing.T, apiServerArgs string) *Reconciler {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{nodeutil.ControlPlaneLabel: ""}}}
+
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "kube-apiserver")
+	if err := os.WriteFile(argsFile, []byte(apiServerArgs), 0600); err != nil {
+		t.Fatalf("Expected no error writing arguments file but received %q", err)
+	}
+	return (&Reconciler{
+		Client:                          fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build(),
+		Node:                            "node",
+		KubeAPIServerArgsFile:           argsFile,
+		AuditPolicyFile:                 filepath.Join(dir, "audit-policy.yaml"),
+		AuditWebhookConfigFile:          filepath.Join(dir, "audit-webhook.kubeconfig"),
+		AuthenticationWebhookConfigFile: filepath.Join(dir, "authentication-webhook.kubeconfig"),
+		AuthorizationWebhookConfigFile:  filepath.Join(dir, "authorization-webhook.kubeconfig"),
+		OIDCCAFile:                      filepath.Join(dir, "oidc-ca.crt"),
+		AdmissionConfigFile:             filepath.Join(dir, "admission-config.yaml"),
+		AdmissionStateFile:              filepath.Join(dir, "operator", "admission-state.json"),
+	}).withRestartPlan()
+}
+
+// readFile returns the contents of a file, failing the test if it cannot be read.
+func readFile(t *testing.T, file string) string {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Expected no error reading %s but received %q", file, err)
+	}
+	return string(b)
+}
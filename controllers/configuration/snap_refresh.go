@@ -0,0 +1,32 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func (r *Reconciler) reconcileSnapRefresh(ctx context.Context, refresh *microk8sv1alpha1.SnapRefreshSpec) error {
+	if refresh == nil || (refresh.Hold == "" && refresh.Timer == "") {
+		return nil
+	}
+	log := log.FromContext(ctx)
+	if r.observeOnly() {
+		log.Info("not configuring snap refresh due to drift policy")
+		return nil
+	}
+	updated, err := r.ConfigureSnapRefresh(ctx, refresh.Hold, refresh.Timer)
+	if err != nil {
+		return fmt.Errorf("failed to configure snap refresh: %w", err)
+	}
+	if !updated {
+		log.Info("snap refresh configuration up to date")
+		return nil
+	}
+	log.Info("updated snap refresh configuration", "hold", refresh.Hold, "timer", refresh.Timer)
+	r.events.Eventf(corev1.EventTypeNormal, reasonSnapRefreshConfigured, "Configured snap refresh hold %q and timer %q", refresh.Hold, refresh.Timer)
+	return nil
+}
@@ -0,0 +1,50 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+// updateNodeStatus sets the status of the current node in the status of the named Configuration objects.
+func (r *Reconciler) updateNodeStatus(ctx context.Context, configNames []string, nodeStatus microk8sv1alpha1.ConfigurationNodeStatus) error {
+	nodeStatus.Name = r.Node
+	for _, name := range configNames {
+		if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			config := &microk8sv1alpha1.Configuration{}
+			if err := r.Client.Get(ctx, types.NamespacedName{Name: name}, config); err != nil {
+				return err
+			}
+			if !setNodeStatus(&config.Status, nodeStatus) {
+				return nil
+			}
+			return r.Client.Status().Update(ctx, config)
+		}); err != nil {
+			return fmt.Errorf("failed to update status of configuration %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setNodeStatus sets the status for a node. It returns false if nothing changed.
+func setNodeStatus(status *microk8sv1alpha1.ConfigurationStatus, nodeStatus microk8sv1alpha1.ConfigurationNodeStatus) bool {
+	for i, existing := range status.Nodes {
+		if existing.Name != nodeStatus.Name {
+			continue
+		}
+		if existing.Phase == nodeStatus.Phase && existing.Message == nodeStatus.Message && reflect.DeepEqual(existing.Drift, nodeStatus.Drift) {
+			return false
+		}
+		nodeStatus.LastTransitionTime = metav1.Now()
+		status.Nodes[i] = nodeStatus
+		return true
+	}
+	nodeStatus.LastTransitionTime = metav1.Now()
+	status.Nodes = append(status.Nodes, nodeStatus)
+	return true
+}
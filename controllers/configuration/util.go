@@ -7,6 +7,8 @@ import (
 	"strings"
 
 	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/hostfile"
+	"github.com/neoaggelos/microk8s-operator/pkg/metrics"
 )
 
 func mergeMaps(base map[string]string, overrides map[string]string) map[string]string {
@@ -31,6 +33,23 @@ func mergeArguments(base map[string]*string, overrides map[string]*string) map[s
 	return m
 }
 
+func mergeSnapRefresh(base, overrides *microk8sv1alpha1.SnapRefreshSpec) *microk8sv1alpha1.SnapRefreshSpec {
+	if base == nil {
+		return overrides
+	}
+	if overrides == nil {
+		return base
+	}
+	result := *base
+	if o := overrides.Hold; o != "" {
+		result.Hold = o
+	}
+	if o := overrides.Timer; o != "" {
+		result.Timer = o
+	}
+	return &result
+}
+
 func mergeConfigSpecs(base, overrides microk8sv1alpha1.ConfigurationSpec) microk8sv1alpha1.ConfigurationSpec {
 	result := microk8sv1alpha1.ConfigurationSpec{}
 
@@ -47,11 +66,43 @@ func mergeConfigSpecs(base, overrides microk8sv1alpha1.ConfigurationSpec) microk
 		result.ContainerdEnv = o
 	}
 	result.ExtraKubeletArgs = mergeArguments(base.ExtraKubeletArgs, overrides.ExtraKubeletArgs)
+	result.KubeletConfig = mergeRawObjects(base.KubeletConfig, overrides.KubeletConfig)
 	result.ExtraAPIServerArgs = mergeArguments(base.ExtraAPIServerArgs, overrides.ExtraAPIServerArgs)
+	result.FeatureGates = mergeFeatureGates(base.FeatureGates, overrides.FeatureGates)
+	result.Images = mergeImages(base.Images, overrides.Images)
+	result.SnapRefresh = mergeSnapRefresh(base.SnapRefresh, overrides.SnapRefresh)
+	result.DisruptionPolicy = base.DisruptionPolicy
+	if o := overrides.DisruptionPolicy; o != nil {
+		result.DisruptionPolicy = o
+	}
+	result.Authentication = base.Authentication
+	if o := overrides.Authentication; o != nil {
+		result.Authentication = o
+	}
+	result.Admission = mergeAdmission(base.Admission, overrides.Admission)
+	result.Audit = base.Audit
+	if o := overrides.Audit; o != nil {
+		result.Audit = o
+	}
+	result.DriftPolicy = base.DriftPolicy
+	if o := overrides.DriftPolicy; o != "" {
+		result.DriftPolicy = o
+	}
 
 	return result
 }
 
+// argumentPath returns the path of file for use in service arguments.
+func (r *Reconciler) argumentPath(file string) *string {
+	if r.ArgumentPath != nil {
+		file = r.ArgumentPath(file)
+	}
+	return &file
+}
+
+// updateFunc updates a file with new contents. It returns true if the file was changed.
+type updateFunc func(file string, newContents string, perm fs.FileMode) (bool, error)
+
 func updateFile(file string, newContents string, perm fs.FileMode) (bool, error) {
 	b, err := os.ReadFile(file)
 	if err != nil && !os.IsNotExist(err) {
@@ -61,8 +112,51 @@ func updateFile(file string, newContents string, perm fs.FileMode) (bool, error)
 		return false, nil
 	}
 
-	if err := os.WriteFile(file, []byte(newContents), perm); err != nil {
+	if err := hostfile.WriteAtomic(file, []byte(newContents), perm); err != nil {
 		return false, fmt.Errorf("failed to write file: %w", err)
 	}
+	metrics.FileUpdates.WithLabelValues(file).Inc()
 	return true, nil
 }
+
+// fileState is the contents of a file before it was updated, used to roll back changes.
+type fileState struct {
+	path     string
+	contents []byte
+	exists   bool
+	perm     fs.FileMode
+}
+
+// snapshotFile returns the current state of a file.
+func snapshotFile(file string) (fileState, error) {
+	state := fileState{path: file}
+	info, err := os.Stat(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, fmt.Errorf("failed to stat file: %w", err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return state, fmt.Errorf("failed to read file: %w", err)
+	}
+	state.contents = b
+	state.exists = true
+	state.perm = info.Mode().Perm()
+	return state, nil
+}
+
+// restore restores the file to the snapshotted state.
+func (s fileState) restore() error {
+	if !s.exists {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove file: %w", err)
+		}
+		return nil
+	}
+	if _, err := updateFile(s.path, string(s.contents), s.perm); err != nil {
+		return err
+	}
+	return nil
+}
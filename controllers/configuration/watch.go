@@ -0,0 +1,118 @@
+package configuration
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/hostfile"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// managedFiles returns the files on the node that are managed by the reconciler.
+// Files under RegistryCertsDir are also managed, see isManagedFile.
+func (r *Reconciler) managedFiles() []string {
+	var files []string
+	for _, file := range []string{
+		r.ContainerdEnvFile, r.CSRConfFile, r.KubeletArgsFile, r.KubeAPIServerArgsFile, r.KubeletConfigFile,
+		r.AuditPolicyFile, r.AuditWebhookConfigFile, r.EncryptionConfigFile,
+		r.OIDCCAFile, r.AuthenticationWebhookConfigFile, r.AuthorizationWebhookConfigFile,
+		r.AdmissionConfigFile,
+	} {
+		if file != "" {
+			files = append(files, filepath.Clean(file))
+		}
+	}
+	return files
+}
+
+// isManagedFile returns true if path is a file managed by the reconciler.
+func (r *Reconciler) isManagedFile(path string) bool {
+	path = filepath.Clean(path)
+	if hostfile.IsTemporary(path) {
+		return false
+	}
+	for _, file := range r.managedFiles() {
+		if path == file {
+			return true
+		}
+	}
+	if r.RegistryCertsDir != "" {
+		dir := filepath.Clean(r.RegistryCertsDir)
+		return path != dir && strings.HasPrefix(path, dir+string(filepath.Separator))
+	}
+	return false
+}
+
+// watchManagedFiles watches the managed files on the node, and sends an event for the default Configuration
+// whenever one of them changes. Parent directories are watched, so that files replaced by editors or by snap
+// refreshes are also noticed. It blocks until the context is cancelled.
+func (r *Reconciler) watchManagedFiles(ctx context.Context, events chan<- event.GenericEvent) error {
+	log := log.FromContext(ctx).WithName("watch")
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		// drift is still corrected on the periodic resync
+		log.Error(err, "failed to create file watcher, changes to managed files will not be noticed")
+		return nil
+	}
+	defer watcher.Close()
+
+	dirs := make(map[string]struct{})
+	for _, file := range r.managedFiles() {
+		dirs[filepath.Dir(file)] = struct{}{}
+	}
+	if r.RegistryCertsDir != "" {
+		dirs[filepath.Clean(r.RegistryCertsDir)] = struct{}{}
+		if entries, err := os.ReadDir(r.RegistryCertsDir); err == nil {
+			for _, entry := range entries {
+				if entry.IsDir() {
+					dirs[filepath.Join(r.RegistryCertsDir, entry.Name())] = struct{}{}
+				}
+			}
+		}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			log.Error(err, "failed to watch directory", "dir", dir)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error(err, "file watcher error")
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op == fsnotify.Chmod || !r.isManagedFile(ev.Name) {
+				continue
+			}
+			// watch new registry directories for their hosts.toml files
+			if ev.Op&fsnotify.Create != 0 && filepath.Dir(ev.Name) == filepath.Clean(r.RegistryCertsDir) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if err := watcher.Add(ev.Name); err != nil {
+						log.Error(err, "failed to watch directory", "dir", ev.Name)
+					}
+				}
+			}
+			log.V(1).Info("managed file changed", "file", ev.Name, "op", ev.Op.String())
+			select {
+			case events <- event.GenericEvent{Object: &microk8sv1alpha1.Configuration{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package microk8snode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LeaseLabel is set on the heartbeat leases of nodes, with the node name as value.
+const LeaseLabel = "microk8s.canonical.com/node"
+
+// LeaseName returns the name of the heartbeat lease of a node.
+func LeaseName(node string) string {
+	return "microk8snode-" + node
+}
+
+// renewInterval is how often the heartbeat lease is renewed.
+func (c *Controller) renewInterval() time.Duration {
+	if c.LeaseDuration <= 0 || c.LeaseDuration/4 > c.Interval {
+		return c.Interval
+	}
+	return c.LeaseDuration / 4
+}
+
+// renewLease renews the heartbeat lease of the node, creating it if needed. The lease is owned by the
+// MicroK8sNode, so that it is removed along with it.
+func (c *Controller) renewLease(ctx context.Context, node *microk8sv1alpha1.MicroK8sNode) error {
+	leases := c.Clientset.CoordinationV1().Leases(c.LeaseNamespace)
+	now := v1.NewMicroTime(time.Now())
+
+	if c.lease == nil {
+		lease, err := leases.Get(ctx, LeaseName(c.Node), v1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			holder := c.Node
+			durationSeconds := int32(c.LeaseDuration.Seconds())
+			lease, err = leases.Create(ctx, &coordinationv1.Lease{
+				ObjectMeta: v1.ObjectMeta{
+					Name:   LeaseName(c.Node),
+					Labels: map[string]string{LeaseLabel: c.Node},
+					OwnerReferences: []v1.OwnerReference{{
+						APIVersion: microk8sv1alpha1.GroupVersion.String(),
+						Kind:       "MicroK8sNode",
+						Name:       node.Name,
+						UID:        node.UID,
+					}},
+				},
+				Spec: coordinationv1.LeaseSpec{
+					HolderIdentity:       &holder,
+					LeaseDurationSeconds: &durationSeconds,
+					RenewTime:            &now,
+				},
+			}, v1.CreateOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to create lease: %w", err)
+			}
+			c.lease = lease
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to get lease: %w", err)
+		}
+		c.lease = lease
+	}
+
+	lease := c.lease.DeepCopy()
+	lease.Spec.RenewTime = &now
+	updated, err := leases.Update(ctx, lease, v1.UpdateOptions{})
+	if err != nil {
+		// get the lease again on the next renewal
+		c.lease = nil
+		return fmt.Errorf("failed to renew lease: %w", err)
+	}
+	c.lease = updated
+	return nil
+}
@@ -0,0 +1,98 @@
+package microk8snode
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MetadataPrefix is the prefix of the labels and annotations set on the Kubernetes Node.
+const MetadataPrefix = "microk8s.io/"
+
+// metadataFields are the fields of the MicroK8sNode status that can be mirrored onto the Kubernetes Node.
+var metadataFields = map[string]func(status *microk8sv1alpha1.MicroK8sNodeStatus) string{
+	"channel":        func(status *microk8sv1alpha1.MicroK8sNodeStatus) string { return status.Channel },
+	"revision":       func(status *microk8sv1alpha1.MicroK8sNodeStatus) string { return status.Revision },
+	"version":        func(status *microk8sv1alpha1.MicroK8sNodeStatus) string { return status.Version },
+	"confinement":    func(status *microk8sv1alpha1.MicroK8sNodeStatus) string { return status.Confinement },
+	"datastore-role": func(status *microk8sv1alpha1.MicroK8sNodeStatus) string { return status.DatastoreRole },
+	"config-hash":    func(status *microk8sv1alpha1.MicroK8sNodeStatus) string { return status.AppliedConfigHash },
+}
+
+// ValidateMetadataFields returns an error if any of the fields cannot be mirrored onto the Kubernetes Node.
+func ValidateMetadataFields(fields []string) error {
+	for _, field := range fields {
+		if _, ok := metadataFields[field]; !ok {
+			return fmt.Errorf("unknown field %q", field)
+		}
+	}
+	return nil
+}
+
+var invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// labelValue turns a value into a valid label value, e.g. "1.28/stable" becomes "1.28-stable".
+func labelValue(value string) string {
+	value = invalidLabelValueChars.ReplaceAllString(value, "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "-_.")
+}
+
+// setMetadata sets the labels or annotations for fields in m. Fields with empty values are removed, and so are
+// the labels or annotations of supported fields that are not in fields, e.g. after a field is no longer mirrored.
+// It returns true if m changed.
+func setMetadata(m map[string]string, fields []string, status *microk8sv1alpha1.MicroK8sNodeStatus, format func(string) string) bool {
+	enabled := make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		enabled[field] = struct{}{}
+	}
+	changed := false
+	for field, get := range metadataFields {
+		key := MetadataPrefix + field
+		value := ""
+		if _, ok := enabled[field]; ok {
+			value = format(get(status))
+		}
+		if existing, ok := m[key]; value == "" && ok {
+			delete(m, key)
+			changed = true
+		} else if value != "" && existing != value {
+			m[key] = value
+			changed = true
+		}
+	}
+	return changed
+}
+
+// updateNodeMetadata mirrors the configured fields of the MicroK8sNode status onto the Kubernetes Node, and
+// removes the labels and annotations of fields that are not configured.
+func (c *Controller) updateNodeMetadata(ctx context.Context, status *microk8sv1alpha1.MicroK8sNodeStatus) error {
+	node := &corev1.Node{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: c.Node}, node); err != nil {
+		return fmt.Errorf("failed to get kubernetes node: %w", err)
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	labelsChanged := setMetadata(node.Labels, c.NodeLabels, status, labelValue)
+	annotationsChanged := setMetadata(node.Annotations, c.NodeAnnotations, status, func(s string) string { return s })
+	if !labelsChanged && !annotationsChanged {
+		return nil
+	}
+	if err := c.Client.Patch(ctx, node, patch); err != nil {
+		return fmt.Errorf("failed to update kubernetes node: %w", err)
+	}
+	return nil
+}
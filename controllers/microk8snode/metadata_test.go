@@ -0,0 +1,40 @@
+package microk8snode
+
+import (
+	"reflect"
+	"testing"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+)
+
+func TestSetMetadata(t *testing.T) {
+	status := &microk8sv1alpha1.MicroK8sNodeStatus{Channel: "1.28/stable", Revision: "6089"}
+	labels := map[string]string{"microk8s.io/datastore-role": "Voter", "other": "value"}
+
+	if !setMetadata(labels, []string{"channel", "revision", "datastore-role"}, status, labelValue) {
+		t.Fatal("Expected labels to change")
+	}
+	expected := map[string]string{"microk8s.io/channel": "1.28-stable", "microk8s.io/revision": "6089", "other": "value"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("Expected labels %v but got %v", expected, labels)
+	}
+	if setMetadata(labels, []string{"channel", "revision", "datastore-role"}, status, labelValue) {
+		t.Fatal("Expected labels to be up to date")
+	}
+
+	// fields that are no longer mirrored are removed
+	if !setMetadata(labels, []string{"revision"}, status, labelValue) {
+		t.Fatal("Expected labels to change")
+	}
+	expected = map[string]string{"microk8s.io/revision": "6089", "other": "value"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("Expected labels %v but got %v", expected, labels)
+	}
+	if !setMetadata(labels, nil, status, labelValue) {
+		t.Fatal("Expected labels to change")
+	}
+	expected = map[string]string{"other": "value"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("Expected labels %v but got %v", expected, labels)
+	}
+}
@@ -2,12 +2,20 @@ package microk8snode
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/hostfile"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -17,68 +25,286 @@ type SnapInfo struct {
 	Channel     string
 	Version     string
 	Confinement string
+
+	// Services are the microk8s.daemon-* snap services.
+	Services []microk8sv1alpha1.ServiceStatus
+	// DatastoreRole is the dqlite role of the node, see microk8s.DatastoreRole.
+	DatastoreRole string
+}
+
+type RefreshInfo struct {
+	Timer string
+	Hold  string
+	Next  string
+	Last  string
 }
 
+//+kubebuilder:rbac:groups="",resources=pods;services,verbs=list
+//+kubebuilder:rbac:groups=apps,resources=daemonsets;deployments;replicasets;statefulsets,verbs=list
+//+kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=list
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=list
+//+kubebuilder:rbac:groups=coordination.k8s.io,namespace=system,resources=leases,verbs=get;list;create;update
+
 type Controller struct {
 	Client   client.Client
+	Recorder record.EventRecorder
 	Interval time.Duration
 
-	Node     string
-	SnapInfo func(ctx context.Context) (SnapInfo, error)
+	Node        string
+	SnapInfo    func(ctx context.Context) (SnapInfo, error)
+	RefreshInfo func(ctx context.Context) (RefreshInfo, error)
+	// Addons returns the addons enabled on the node.
+	Addons func(ctx context.Context) ([]string, error)
+
+	// BackupDir is where backups of managed files are kept. It is empty if backups are disabled.
+	BackupDir string
+	// HostPath maps a path in the node agent to the respective path on the host. If nil, paths are reported as is.
+	HostPath func(path string) string
+
+	// SnapCommon and SnapData are the MicroK8s directories whose disk usage is reported.
+	SnapCommon string
+	SnapData   string
+	// Images lists the container images on the node.
+	Images func(ctx context.Context) ([]Image, error)
+	// DiskUsageThreshold is the disk usage percentage above which the DiskPressure condition is raised.
+	DiskUsageThreshold int32
+
+	// Clientset is used to renew the heartbeat lease of the node in LeaseNamespace. The status of the node is only
+	// written when it changes, the lease shows that the node agent is alive.
+	Clientset      kubernetes.Interface
+	LeaseNamespace string
+	LeaseDuration  time.Duration
+
+	// NodeLabels and NodeAnnotations are the status fields to mirror onto the Kubernetes Node, with MetadataPrefix.
+	// Label values are sanitized, e.g. channel "1.28/stable" becomes "1.28-stable".
+	NodeLabels      []string
+	NodeAnnotations []string
+
+	lease *coordinationv1.Lease
 }
 
 func (c *Controller) Run(ctx context.Context) error {
 	log := log.FromContext(ctx).WithValues("node", c.Node)
-	// cleanup on exit
-	defer func() {
-		<-ctx.Done()
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	var node *microk8sv1alpha1.MicroK8sNode
+	var nextUpdate time.Time
+	for {
+		if !time.Now().Before(nextUpdate) {
+			if updated := c.update(ctx); updated != nil {
+				node = updated
+			}
+			nextUpdate = time.Now().Add(c.Interval)
+		}
+		if node != nil && c.LeaseDuration > 0 {
+			if err := c.renewLease(ctx, node); err != nil {
+				log.Error(err, "failed to renew node heartbeat")
+			}
+		}
 
-		node := &microk8sv1alpha1.MicroK8sNode{
-			ObjectMeta: v1.ObjectMeta{
-				Name: c.Node,
-			},
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.renewInterval()):
 		}
-		if err := c.Client.Delete(ctx, node, &client.DeleteOptions{}); err != nil {
-			log.Error(err, "failed to delete node during cleanup")
+	}
+}
+
+// getOrCreateNode returns the MicroK8sNode of the local node, creating it if it does not exist. The MicroK8sNode
+// is owned by the Kubernetes Node, so that it is garbage collected when the node is removed from the cluster.
+func (c *Controller) getOrCreateNode(ctx context.Context) (*microk8sv1alpha1.MicroK8sNode, error) {
+	k8sNode := &corev1.Node{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: c.Node}, k8sNode); err != nil {
+		return nil, fmt.Errorf("failed to get kubernetes node: %w", err)
+	}
+	ownerReference := v1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Node",
+		Name:       k8sNode.Name,
+		UID:        k8sNode.UID,
+	}
+
+	node := &microk8sv1alpha1.MicroK8sNode{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: c.Node}, node); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		node = &microk8sv1alpha1.MicroK8sNode{ObjectMeta: v1.ObjectMeta{
+			Name:            c.Node,
+			OwnerReferences: []v1.OwnerReference{ownerReference},
+		}}
+		if err := c.Client.Create(ctx, node); err != nil {
+			return nil, fmt.Errorf("failed to create node: %w", err)
+		}
+		return node, nil
+	}
+
+	for _, ref := range node.OwnerReferences {
+		if ref.UID == ownerReference.UID {
+			return node, nil
 		}
-	}()
+	}
+	// nodes created by older versions, or owned by a previous node with the same name
+	patch := client.MergeFrom(node.DeepCopy())
+	node.OwnerReferences = []v1.OwnerReference{ownerReference}
+	if err := c.Client.Patch(ctx, node, patch); err != nil {
+		return nil, fmt.Errorf("failed to set owner reference: %w", err)
+	}
+	return node, nil
+}
 
-	for {
-		node := &microk8sv1alpha1.MicroK8sNode{}
-		if err := c.Client.Get(ctx, types.NamespacedName{Name: c.Node}, node); err != nil {
-			if apierrors.IsNotFound(err) {
-				if err := c.Client.Create(ctx, &microk8sv1alpha1.MicroK8sNode{ObjectMeta: v1.ObjectMeta{Name: c.Node}}); err != nil {
-					log.Error(err, "failed to create node")
-				}
-			}
+// update refreshes the status of the MicroK8sNode of the local node. The status is only written if it changed.
+// It returns the MicroK8sNode, or nil if it could not be retrieved.
+func (c *Controller) update(ctx context.Context) *microk8sv1alpha1.MicroK8sNode {
+	log := log.FromContext(ctx).WithValues("node", c.Node)
 
-			if err := c.Client.Get(ctx, types.NamespacedName{Name: c.Node}, node); err != nil {
-				log.Error(err, "failed to get node")
-				continue
+	node, err := c.getOrCreateNode(ctx)
+	if err != nil {
+		log.Error(err, "failed to get node")
+		return nil
+	}
+	previous := node.Status.DeepCopy()
+
+	snapInfo, err := c.SnapInfo(ctx)
+	if err != nil {
+		log.Error(err, "failed to retrieve microk8s snap info")
+		c.Recorder.Eventf(node, corev1.EventTypeWarning, "SnapInfoFailed", "Failed to retrieve microk8s snap info: %v", err)
+	} else if node.Status.Revision != "" && node.Status.Revision != snapInfo.Revision {
+		log.Info("microk8s snap was refreshed", "revision", snapInfo.Revision, "channel", snapInfo.Channel)
+		c.Recorder.Eventf(node, corev1.EventTypeNormal, "SnapRefreshed", "MicroK8s snap refreshed from revision %s to %s (%s, %s)", node.Status.Revision, snapInfo.Revision, snapInfo.Channel, snapInfo.Version)
+	}
+	node.Status.Channel = snapInfo.Channel
+	node.Status.Revision = snapInfo.Revision
+	node.Status.Version = snapInfo.Version
+	node.Status.Confinement = snapInfo.Confinement
+	if err == nil {
+		node.Status.Services = snapInfo.Services
+		node.Status.DatastoreRole = snapInfo.DatastoreRole
+	}
+
+	if c.Addons != nil {
+		if addons, err := c.Addons(ctx); err != nil {
+			log.Error(err, "failed to retrieve enabled addons")
+		} else {
+			node.Status.Addons = addons
+		}
+	}
+
+	refreshInfo, err := c.RefreshInfo(ctx)
+	if err != nil {
+		log.Error(err, "failed to retrieve snap refresh info")
+		c.Recorder.Eventf(node, corev1.EventTypeWarning, "RefreshInfoFailed", "Failed to retrieve snap refresh info: %v", err)
+	}
+	node.Status.Refresh = microk8sv1alpha1.SnapRefreshStatus{
+		Timer: refreshInfo.Timer,
+		Hold:  refreshInfo.Hold,
+		Next:  refreshInfo.Next,
+		Last:  refreshInfo.Last,
+	}
+	if c.BackupDir != "" {
+		backups, err := hostfile.Backups{Dir: c.BackupDir}.List()
+		if err != nil {
+			log.Error(err, "failed to list file backups")
+		} else {
+			node.Status.Backups = make([]microk8sv1alpha1.FileBackup, 0, len(backups))
+			for _, backup := range backups {
+				node.Status.Backups = append(node.Status.Backups, microk8sv1alpha1.FileBackup{
+					File: c.hostPath(backup.File),
+					Path: c.hostPath(backup.Path),
+					Time: v1.NewTime(backup.Time),
+				})
 			}
 		}
+	}
+	c.updateStorageStatus(ctx, node)
+	meta.RemoveStatusCondition(&node.Status.Conditions, microk8sv1alpha1.ConditionStale)
+
+	if err := c.updateNodeMetadata(ctx, &node.Status); err != nil {
+		log.Error(err, "failed to update node labels and annotations")
+	}
+	if !statusChanged(previous, &node.Status) {
+		return node
+	}
+	node.Status.LastUpdate.Time = time.Now()
+	if err := c.Client.Status().Update(ctx, node); err != nil {
+		log.Error(err, "failed to update node")
+		c.Recorder.Eventf(node, corev1.EventTypeWarning, "StatusUpdateFailed", "Failed to update node status: %v", err)
+	}
+	return node
+}
+
+// statusChanged returns true if the status changed. Small changes in disk usage are ignored, and the previous
+// disk usage is kept in current unless the used percentage changes.
+func statusChanged(previous, current *microk8sv1alpha1.MicroK8sNodeStatus) bool {
+	for _, usage := range []struct{ previous, current **microk8sv1alpha1.DiskUsage }{
+		{previous: &previous.Storage.SnapCommon, current: &current.Storage.SnapCommon},
+		{previous: &previous.Storage.SnapData, current: &current.Storage.SnapData},
+	} {
+		p, c := *usage.previous, *usage.current
+		if p != nil && c != nil && p.Path == c.Path && p.UsedPercent == c.UsedPercent {
+			*usage.current = p.DeepCopy()
+		}
+	}
+	current.LastUpdate = previous.LastUpdate
+	return !equality.Semantic.DeepEqual(previous, current)
+}
+
+// updateStorageStatus updates the storage usage and the DiskPressure condition of the node.
+func (c *Controller) updateStorageStatus(ctx context.Context, node *microk8sv1alpha1.MicroK8sNode) {
+	log := log.FromContext(ctx)
 
-		snapInfo, err := c.SnapInfo(ctx)
+	storage := microk8sv1alpha1.StorageStatus{}
+	for _, dir := range []struct {
+		path  string
+		usage **microk8sv1alpha1.DiskUsage
+	}{
+		{path: c.SnapCommon, usage: &storage.SnapCommon},
+		{path: c.SnapData, usage: &storage.SnapData},
+	} {
+		if dir.path == "" {
+			continue
+		}
+		usage, err := diskUsage(dir.path)
 		if err != nil {
-			log.Error(err, "failed to retrieve microk8s snap info")
+			log.Error(err, "failed to retrieve disk usage", "path", dir.path)
+			continue
 		}
-		node.Status.Channel = snapInfo.Channel
-		node.Status.Revision = snapInfo.Revision
-		node.Status.Version = snapInfo.Version
-		node.Status.Confinement = snapInfo.Confinement
-		node.Status.LastUpdate.Time = time.Now()
-
-		if err := c.Client.Status().Update(ctx, node); err != nil {
-			log.Error(err, "failed to update node")
+		*dir.usage = usage
+	}
+	if c.Images != nil {
+		if images, err := c.Images(ctx); err != nil {
+			// keep the last known image usage
+			log.Error(err, "failed to list images")
+			storage.ImageCount = node.Status.Storage.ImageCount
+			storage.ImagesBytes = node.Status.Storage.ImagesBytes
+			storage.LargestImages = node.Status.Storage.LargestImages
+		} else {
+			storage.ImageCount, storage.ImagesBytes, storage.LargestImages = imageUsage(images)
 		}
+	}
+	node.Status.Storage = storage
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(c.Interval):
+	if c.DiskUsageThreshold <= 0 {
+		return
+	}
+	condition := diskPressureCondition(storage, c.DiskUsageThreshold)
+	condition.ObservedGeneration = node.Generation
+	existing := meta.FindStatusCondition(node.Status.Conditions, condition.Type)
+	if existing == nil || existing.Status != condition.Status {
+		if condition.Status == v1.ConditionTrue {
+			log.Info("disk usage is above the threshold", "message", condition.Message)
+			c.Recorder.Event(node, corev1.EventTypeWarning, condition.Reason, condition.Message)
+		} else if existing != nil {
+			log.Info("disk usage is below the threshold")
+			c.Recorder.Event(node, corev1.EventTypeNormal, condition.Reason, condition.Message)
 		}
 	}
+	meta.SetStatusCondition(&node.Status.Conditions, condition)
+}
+
+// hostPath returns the path on the host for a path in the node agent.
+func (c *Controller) hostPath(path string) string {
+	if c.HostPath == nil {
+		return path
+	}
+	return c.HostPath(path)
 }
@@ -0,0 +1,78 @@
+package microk8snode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// largestImages is how many of the largest images are reported in the node status.
+const largestImages = 5
+
+// Image is a container image on the node.
+type Image struct {
+	Names []string
+	Size  uint64
+}
+
+// diskUsage returns the usage of the filesystem that holds path.
+func diskUsage(path string) (*microk8sv1alpha1.DiskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	total := int64(st.Blocks) * int64(st.Bsize)
+	free := int64(st.Bfree) * int64(st.Bsize)
+	usage := &microk8sv1alpha1.DiskUsage{
+		Path:           path,
+		TotalBytes:     total,
+		AvailableBytes: int64(st.Bavail) * int64(st.Bsize),
+	}
+	if total > 0 {
+		usage.UsedPercent = int32((total - free) * 100 / total)
+	}
+	return usage, nil
+}
+
+// imageUsage returns the image count, total size and largest images.
+func imageUsage(images []Image) (int32, int64, []microk8sv1alpha1.ImageUsage) {
+	var total int64
+	usages := make([]microk8sv1alpha1.ImageUsage, 0, len(images))
+	for _, image := range images {
+		total += int64(image.Size)
+		usages = append(usages, microk8sv1alpha1.ImageUsage{Names: image.Names, SizeBytes: int64(image.Size)})
+	}
+	sort.SliceStable(usages, func(i, j int) bool { return usages[i].SizeBytes > usages[j].SizeBytes })
+	if len(usages) > largestImages {
+		usages = usages[:largestImages]
+	}
+	return int32(len(images)), total, usages
+}
+
+// diskPressureCondition returns the DiskPressure condition for the storage status.
+func diskPressureCondition(storage microk8sv1alpha1.StorageStatus, threshold int32) metav1.Condition {
+	var above []string
+	for _, usage := range []*microk8sv1alpha1.DiskUsage{storage.SnapCommon, storage.SnapData} {
+		if usage != nil && usage.UsedPercent >= threshold {
+			above = append(above, fmt.Sprintf("%s is %d%% full", usage.Path, usage.UsedPercent))
+		}
+	}
+	if len(above) > 0 {
+		return metav1.Condition{
+			Type:    microk8sv1alpha1.ConditionDiskPressure,
+			Status:  metav1.ConditionTrue,
+			Reason:  "DiskUsageAboveThreshold",
+			Message: fmt.Sprintf("%s (threshold is %d%%)", strings.Join(above, ", "), threshold),
+		}
+	}
+	return metav1.Condition{
+		Type:    microk8sv1alpha1.ConditionDiskPressure,
+		Status:  metav1.ConditionFalse,
+		Reason:  "DiskUsageBelowThreshold",
+		Message: fmt.Sprintf("disk usage is below the threshold of %d%%", threshold),
+	}
+}
@@ -0,0 +1,73 @@
+package microk8snode
+
+import (
+	"reflect"
+	"testing"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestImageUsage(t *testing.T) {
+	var images []Image
+	for i := uint64(1); i <= 7; i++ {
+		images = append(images, Image{Names: []string{string(rune('a' + i))}, Size: i * 100})
+	}
+	count, total, largest := imageUsage(images)
+	if count != 7 || total != 2800 {
+		t.Fatalf("Expected 7 images of 2800 bytes but got %d images of %d bytes", count, total)
+	}
+	sizes := make([]int64, 0, len(largest))
+	for _, image := range largest {
+		sizes = append(sizes, image.SizeBytes)
+	}
+	if expected := []int64{700, 600, 500, 400, 300}; !reflect.DeepEqual(sizes, expected) {
+		t.Fatalf("Expected largest images %v but got %v", expected, sizes)
+	}
+}
+
+func TestDiskPressureCondition(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		common int32
+		data   int32
+		status metav1.ConditionStatus
+	}{
+		{name: "below", common: 50, data: 10, status: metav1.ConditionFalse},
+		{name: "common", common: 85, data: 10, status: metav1.ConditionTrue},
+		{name: "data", common: 10, data: 95, status: metav1.ConditionTrue},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			condition := diskPressureCondition(microk8sv1alpha1.StorageStatus{
+				SnapCommon: &microk8sv1alpha1.DiskUsage{Path: "/common", UsedPercent: tc.common},
+				SnapData:   &microk8sv1alpha1.DiskUsage{Path: "/data", UsedPercent: tc.data},
+			}, 85)
+			if condition.Status != tc.status {
+				t.Fatalf("Expected condition status %q but got %q (%s)", tc.status, condition.Status, condition.Message)
+			}
+		})
+	}
+}
+
+func TestStatusChanged(t *testing.T) {
+	previous := &microk8sv1alpha1.MicroK8sNodeStatus{
+		Version: "v1.24.0",
+		Storage: microk8sv1alpha1.StorageStatus{
+			SnapCommon: &microk8sv1alpha1.DiskUsage{Path: "/common", AvailableBytes: 1000, UsedPercent: 50},
+		},
+	}
+	current := previous.DeepCopy()
+	current.LastUpdate = metav1.Now()
+	current.Storage.SnapCommon.AvailableBytes = 900
+	if statusChanged(previous, current) {
+		t.Fatal("Expected no change when only the available bytes changed")
+	}
+	if current.Storage.SnapCommon.AvailableBytes != 1000 {
+		t.Fatalf("Expected previous disk usage to be kept but got %d available bytes", current.Storage.SnapCommon.AvailableBytes)
+	}
+
+	current.Storage.SnapCommon.UsedPercent = 51
+	if !statusChanged(previous, current) {
+		t.Fatal("Expected change when the used percentage changed")
+	}
+}
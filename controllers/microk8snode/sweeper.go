@@ -0,0 +1,137 @@
+package microk8snode
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Sweeper marks MicroK8sNodes that are no longer updated as stale, and deletes them after a while. A node is
+// last seen at the latest of its heartbeat lease renewal and its last status update.
+//
+// The sweeper runs on every node, but only the node with the lowest name among the nodes that are not stale
+// sweeps. Status updates and deletions use preconditions, so concurrent sweepers are harmless.
+type Sweeper struct {
+	Client   client.Client
+	Recorder record.EventRecorder
+	Interval time.Duration
+
+	Node string
+
+	// Clientset is used to list the heartbeat leases of nodes in LeaseNamespace.
+	Clientset      kubernetes.Interface
+	LeaseNamespace string
+
+	// StaleAfter is how long after the last update a node is marked as stale.
+	StaleAfter time.Duration
+	// DeleteAfter is how long after the last update a stale node is deleted.
+	DeleteAfter time.Duration
+}
+
+func (s *Sweeper) Run(ctx context.Context) error {
+	log := log.FromContext(ctx).WithValues("node", s.Node)
+	for {
+		if err := s.sweep(ctx); err != nil {
+			log.Error(err, "failed to garbage collect stale nodes")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// lastSeen returns when a node was last seen, given the renew times of the heartbeat leases.
+func lastSeen(node *microk8sv1alpha1.MicroK8sNode, heartbeats map[string]time.Time) time.Time {
+	if heartbeat, ok := heartbeats[node.Name]; ok && heartbeat.After(node.Status.LastUpdate.Time) {
+		return heartbeat
+	}
+	return node.Status.LastUpdate.Time
+}
+
+// sweepActions returns the nodes to mark as stale and to delete. It returns nothing if node is not the sweeper.
+func sweepActions(nodes []microk8sv1alpha1.MicroK8sNode, heartbeats map[string]time.Time, node string, now time.Time, staleAfter, deleteAfter time.Duration) (stale, remove []*microk8sv1alpha1.MicroK8sNode) {
+	var live []string
+	for i := range nodes {
+		if nodes[i].Name == node || now.Sub(lastSeen(&nodes[i], heartbeats)) < staleAfter {
+			live = append(live, nodes[i].Name)
+		}
+	}
+	sort.Strings(live)
+	if len(live) == 0 || live[0] != node {
+		return nil, nil
+	}
+
+	for i := range nodes {
+		n := &nodes[i]
+		age := now.Sub(lastSeen(n, heartbeats))
+		switch {
+		case n.Name == node || age < staleAfter:
+		case age >= deleteAfter:
+			remove = append(remove, n)
+		case !meta.IsStatusConditionTrue(n.Status.Conditions, microk8sv1alpha1.ConditionStale):
+			stale = append(stale, n)
+		}
+	}
+	return stale, remove
+}
+
+func (s *Sweeper) sweep(ctx context.Context) error {
+	log := log.FromContext(ctx)
+
+	nodes := &microk8sv1alpha1.MicroK8sNodeList{}
+	if err := s.Client.List(ctx, nodes); err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	leases, err := s.Clientset.CoordinationV1().Leases(s.LeaseNamespace).List(ctx, v1.ListOptions{LabelSelector: LeaseLabel})
+	if err != nil {
+		return fmt.Errorf("failed to list node heartbeats: %w", err)
+	}
+	heartbeats := make(map[string]time.Time, len(leases.Items))
+	for _, lease := range leases.Items {
+		if lease.Spec.RenewTime != nil {
+			heartbeats[lease.Labels[LeaseLabel]] = lease.Spec.RenewTime.Time
+		}
+	}
+
+	stale, remove := sweepActions(nodes.Items, heartbeats, s.Node, time.Now(), s.StaleAfter, s.DeleteAfter)
+	for _, node := range stale {
+		message := fmt.Sprintf("node was last seen at %s", lastSeen(node, heartbeats).Format(time.RFC3339))
+		meta.SetStatusCondition(&node.Status.Conditions, v1.Condition{
+			Type:               microk8sv1alpha1.ConditionStale,
+			Status:             v1.ConditionTrue,
+			Reason:             "NodeNotUpdated",
+			Message:            message,
+			ObservedGeneration: node.Generation,
+		})
+		// the update fails with a conflict if the node was updated in the meantime
+		if err := s.Client.Status().Update(ctx, node); err != nil {
+			log.Error(err, "failed to mark node as stale", "stale", node.Name)
+			continue
+		}
+		log.Info("marked node as stale", "stale", node.Name, "lastSeen", lastSeen(node, heartbeats))
+		s.Recorder.Event(node, corev1.EventTypeWarning, "NodeStale", message)
+	}
+	for _, node := range remove {
+		if err := s.Client.Delete(ctx, node, client.Preconditions{UID: &node.UID, ResourceVersion: &node.ResourceVersion}); err != nil && !apierrors.IsNotFound(err) {
+			log.Error(err, "failed to delete stale node", "stale", node.Name)
+			continue
+		}
+		log.Info("deleted stale node", "stale", node.Name, "lastSeen", lastSeen(node, heartbeats))
+	}
+	return nil
+}
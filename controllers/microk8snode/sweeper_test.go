@@ -0,0 +1,55 @@
+package microk8snode
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSweepActions(t *testing.T) {
+	now := time.Now()
+	node := func(name string, age time.Duration, stale bool) microk8sv1alpha1.MicroK8sNode {
+		n := microk8sv1alpha1.MicroK8sNode{ObjectMeta: v1.ObjectMeta{Name: name}}
+		n.Status.LastUpdate = v1.NewTime(now.Add(-age))
+		if stale {
+			n.Status.Conditions = []v1.Condition{{Type: microk8sv1alpha1.ConditionStale, Status: v1.ConditionTrue}}
+		}
+		return n
+	}
+	nodes := []microk8sv1alpha1.MicroK8sNode{
+		node("a", 2*time.Hour, false),
+		node("b", time.Minute, false),
+		node("c", 2*time.Hour, true),
+		node("d", 48*time.Hour, true),
+		node("e", 3*time.Hour, false),
+		node("f", 3*time.Hour, false),
+	}
+	heartbeats := map[string]time.Time{"f": now.Add(-time.Minute), "e": now.Add(-4 * time.Hour)}
+	names := func(nodes []*microk8sv1alpha1.MicroK8sNode) []string {
+		var result []string
+		for _, n := range nodes {
+			result = append(result, n.Name)
+		}
+		return result
+	}
+
+	t.Run("Sweeper", func(t *testing.T) {
+		stale, remove := sweepActions(nodes, heartbeats, "b", now, 10*time.Minute, 24*time.Hour)
+		if expected := []string{"a", "e"}; !reflect.DeepEqual(names(stale), expected) {
+			t.Fatalf("Expected stale nodes %v but got %v", expected, names(stale))
+		}
+		if expected := []string{"d"}; !reflect.DeepEqual(names(remove), expected) {
+			t.Fatalf("Expected deleted nodes %v but got %v", expected, names(remove))
+		}
+	})
+
+	t.Run("NotSweeper", func(t *testing.T) {
+		stale, remove := sweepActions(append(nodes, node("0", time.Minute, false)), heartbeats, "b", now, 10*time.Minute, 24*time.Hour)
+		if len(stale) > 0 || len(remove) > 0 {
+			t.Fatalf("Expected no actions but got stale %v and deleted %v", names(stale), names(remove))
+		}
+	})
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2022 Angelos Kolaitis.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeoperation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// Reconciler runs MicroK8sNodeOperations on the local node.
+//
+// The reconciler runs on every node and only picks up operations for its own node. An operation is run at most
+// once: it is marked as Running with a conflict-safe status update before it starts, and operations that are
+// found Running (e.g. because the node agent restarted) are marked as Failed instead of being run again.
+type Reconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Node information
+	Node string
+
+	RestartService      func(ctx context.Context, service string) error
+	RefreshCertificates func(ctx context.Context) error
+	// RefetchAddons fetches the latest version of an addon repository, or all if repository is empty.
+	// It returns a summary of the updated repositories.
+	RefetchAddons func(ctx context.Context, repository string) (string, error)
+	// ReloadConfiguration requests the Configuration to be applied again on the node.
+	ReloadConfiguration func(ctx context.Context) error
+}
+
+//+kubebuilder:rbac:groups=microk8s.canonical.com,resources=microk8snodeoperations,verbs=get;list;watch
+//+kubebuilder:rbac:groups=microk8s.canonical.com,resources=microk8snodeoperations/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile runs a MicroK8sNodeOperation if it targets the local node and has not been picked up yet.
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	op := &microk8sv1alpha1.MicroK8sNodeOperation{}
+	if err := r.Client.Get(ctx, req.NamespacedName, op); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if op.Spec.Node != r.Node {
+		return ctrl.Result{}, nil
+	}
+
+	switch op.Status.Phase {
+	case microk8sv1alpha1.NodeOperationPhaseSucceeded, microk8sv1alpha1.NodeOperationPhaseFailed:
+		return ctrl.Result{}, nil
+	case microk8sv1alpha1.NodeOperationPhaseRunning:
+		log.Info("operation was interrupted", "action", op.Spec.Action)
+		return ctrl.Result{}, r.complete(ctx, op, "", fmt.Errorf("operation was interrupted by a restart of the node agent"))
+	}
+
+	now := metav1.Now()
+	op.Status.Phase = microk8sv1alpha1.NodeOperationPhaseRunning
+	op.Status.StartTime = &now
+	// fails with a conflict if the operation was picked up in the meantime
+	if err := r.Client.Status().Update(ctx, op); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to mark operation as running: %w", err)
+	}
+
+	log.Info("running operation", "action", op.Spec.Action)
+	r.Recorder.Eventf(op, corev1.EventTypeNormal, "OperationStarted", "Running %s on node %s", op.Spec.Action, r.Node)
+	output, err := r.run(ctx, op.Spec)
+	if err != nil {
+		log.Error(err, "operation failed", "action", op.Spec.Action)
+	} else {
+		log.Info("operation succeeded", "action", op.Spec.Action)
+	}
+	return ctrl.Result{}, r.complete(ctx, op, output, err)
+}
+
+// run executes an operation and returns its output.
+func (r *Reconciler) run(ctx context.Context, spec microk8sv1alpha1.MicroK8sNodeOperationSpec) (string, error) {
+	switch spec.Action {
+	case microk8sv1alpha1.NodeOperationRestartService:
+		service := spec.Service
+		if !strings.HasPrefix(service, "daemon-") {
+			service = "daemon-" + service
+		}
+		if service == "daemon-" {
+			return "", fmt.Errorf("service must be specified for %s", spec.Action)
+		}
+		if err := r.RestartService(ctx, "microk8s."+service); err != nil {
+			return "", fmt.Errorf("failed to restart microk8s.%s: %w", service, err)
+		}
+		return fmt.Sprintf("restarted microk8s.%s", service), nil
+	case microk8sv1alpha1.NodeOperationRefreshCertificates:
+		if err := r.RefreshCertificates(ctx); err != nil {
+			return "", fmt.Errorf("failed to refresh certificates: %w", err)
+		}
+		return "refreshed certificates", nil
+	case microk8sv1alpha1.NodeOperationRefetchAddons:
+		return r.RefetchAddons(ctx, spec.Repository)
+	case microk8sv1alpha1.NodeOperationReloadConfiguration:
+		if err := r.ReloadConfiguration(ctx); err != nil {
+			return "", fmt.Errorf("failed to reload configuration: %w", err)
+		}
+		return "requested the configuration to be applied again, see the MicroK8sNode events for the result", nil
+	default:
+		return "", fmt.Errorf("unknown action %q", spec.Action)
+	}
+}
+
+// complete records the result of an operation.
+func (r *Reconciler) complete(ctx context.Context, op *microk8sv1alpha1.MicroK8sNodeOperation, output string, opErr error) error {
+	now := metav1.Now()
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(op), op); err != nil {
+			return err
+		}
+		op.Status.Phase = microk8sv1alpha1.NodeOperationPhaseSucceeded
+		op.Status.Message = ""
+		op.Status.Output = output
+		op.Status.CompletionTime = &now
+		if opErr != nil {
+			op.Status.Phase = microk8sv1alpha1.NodeOperationPhaseFailed
+			op.Status.Message = opErr.Error()
+		}
+		return r.Client.Status().Update(ctx, op)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record operation result: %w", err)
+	}
+
+	if opErr != nil {
+		r.Recorder.Eventf(op, corev1.EventTypeWarning, "OperationFailed", "Failed to run %s on node %s: %v", op.Spec.Action, r.Node, opErr)
+	} else {
+		r.Recorder.Eventf(op, corev1.EventTypeNormal, "OperationSucceeded", "Completed %s on node %s", op.Spec.Action, r.Node)
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&microk8sv1alpha1.MicroK8sNodeOperation{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			op, ok := obj.(*microk8sv1alpha1.MicroK8sNodeOperation)
+			return ok && op.Spec.Node == r.Node
+		}))).
+		Complete(r)
+}
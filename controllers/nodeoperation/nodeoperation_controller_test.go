@@ -0,0 +1,33 @@
+package nodeoperation
+
+import (
+	"context"
+	"testing"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+)
+
+func TestRunRestartService(t *testing.T) {
+	var restarted []string
+	r := &Reconciler{
+		RestartService: func(ctx context.Context, service string) error {
+			restarted = append(restarted, service)
+			return nil
+		},
+	}
+	for _, service := range []string{"containerd", "daemon-containerd"} {
+		if _, err := r.run(context.Background(), microk8sv1alpha1.MicroK8sNodeOperationSpec{
+			Action:  microk8sv1alpha1.NodeOperationRestartService,
+			Service: service,
+		}); err != nil {
+			t.Fatalf("Expected no error restarting %q but received %q", service, err)
+		}
+	}
+	if len(restarted) != 2 || restarted[0] != "microk8s.daemon-containerd" || restarted[1] != "microk8s.daemon-containerd" {
+		t.Fatalf("Expected microk8s.daemon-containerd to be restarted twice but got %v", restarted)
+	}
+
+	if _, err := r.run(context.Background(), microk8sv1alpha1.MicroK8sNodeOperationSpec{Action: microk8sv1alpha1.NodeOperationRestartService}); err == nil {
+		t.Fatal("Expected an error when no service is specified")
+	}
+}
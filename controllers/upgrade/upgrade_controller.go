@@ -0,0 +1,381 @@
+/*
+Copyright 2022 Angelos Kolaitis.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/controllers/microk8snode"
+	"github.com/neoaggelos/microk8s-operator/pkg/nodeutil"
+	"github.com/neoaggelos/microk8s-operator/pkg/skew"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultDrainTimeout = 5 * time.Minute
+	defaultReadyTimeout = 10 * time.Minute
+	// refreshTimeout is the maximum time to wait for the snapd change that refreshes the MicroK8s snap.
+	refreshTimeout = 15 * time.Minute
+
+	// drainPollInterval is how often to retry evicting pods from a draining node.
+	drainPollInterval = 5 * time.Second
+	// refreshPollInterval is how often to check whether the MicroK8s snap refresh is complete.
+	refreshPollInterval = 5 * time.Second
+	// readyPollInterval is how often to check whether a refreshed node is ready.
+	readyPollInterval = 10 * time.Second
+)
+
+// Reconciler reconciles a MicroK8sUpgrade object.
+//
+// The reconciler runs on every node. Nodes are refreshed one at a time, control plane nodes first.
+// Each node agent only acts on its own entry in the status of the MicroK8sUpgrade object, so that
+// the next node agent can pick up once a node is done. The upgrade is planned by the agent of the
+// first node in the plan, and completed or halted by the agent of the node that finished last.
+type Reconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Clientset is used to cordon and drain nodes.
+	Clientset kubernetes.Interface
+
+	// Node information
+	Node string
+
+	// RefreshSnap starts refreshing the MicroK8s snap and returns the ID of the snapd change.
+	// The change ID is empty if the snap is already up to date.
+	RefreshSnap func(ctx context.Context, channel, revision string) (string, error)
+	// RefreshDone returns true once the snapd change is complete, or an error if it failed.
+	RefreshDone func(ctx context.Context, changeID string) (bool, error)
+	// ResolveVersion returns the MicroK8s snap version of a channel or revision from the snap store,
+	// or an empty string if it is not known.
+	ResolveVersion func(ctx context.Context, channel, revision string) (string, error)
+	SnapInfo       func(ctx context.Context) (microk8snode.SnapInfo, error)
+}
+
+//+kubebuilder:rbac:groups=microk8s.canonical.com,resources=microk8supgrades,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=microk8s.canonical.com,resources=microk8supgrades/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
+
+// Reconcile moves a MicroK8sUpgrade forward, refreshing the current node if it is our turn.
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	upgrade := &microk8sv1alpha1.MicroK8sUpgrade{}
+	if err := r.Client.Get(ctx, req.NamespacedName, upgrade); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	switch upgrade.Status.Phase {
+	case microk8sv1alpha1.UpgradePhaseCompleted, microk8sv1alpha1.UpgradePhaseFailed:
+		return ctrl.Result{}, nil
+	case "":
+		nodes, err := r.planNodes(ctx)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to plan upgrade: %w", err)
+		}
+		if len(nodes) > 0 && nodes[0].Name != r.Node {
+			// the node agent of the first node plans the upgrade
+			return ctrl.Result{}, nil
+		}
+		if upgrade.Spec.Channel == "" && upgrade.Spec.Revision == "" {
+			upgrade.Status.Phase = microk8sv1alpha1.UpgradePhaseFailed
+			upgrade.Status.Message = "one of channel or revision must be specified"
+			return ctrl.Result{}, r.Client.Status().Update(ctx, upgrade)
+		}
+		if len(nodes) == 0 {
+			upgrade.Status.Phase = microk8sv1alpha1.UpgradePhaseFailed
+			upgrade.Status.Message = "no nodes with a running node agent to upgrade"
+			return ctrl.Result{}, r.Client.Status().Update(ctx, upgrade)
+		}
+		upgrade.Status.Phase = microk8sv1alpha1.UpgradePhaseInProgress
+		upgrade.Status.Nodes = nodes
+		log.Info("planned upgrade", "nodes", len(nodes))
+		return ctrl.Result{}, r.Client.Status().Update(ctx, upgrade)
+	}
+
+	var nodeStatus *microk8sv1alpha1.MicroK8sUpgradeNodeStatus
+	for i := range upgrade.Status.Nodes {
+		if n := &upgrade.Status.Nodes[i]; n.Phase != microk8sv1alpha1.UpgradePhaseCompleted {
+			nodeStatus = n
+			break
+		}
+	}
+	if nodeStatus == nil || nodeStatus.Name != r.Node || nodeStatus.Phase == microk8sv1alpha1.UpgradePhaseFailed {
+		// another node agent is responsible for the next step
+		return ctrl.Result{}, nil
+	}
+
+	upgrade.Status.CurrentNode = r.Node
+	result := r.upgradeNode(ctx, upgrade, nodeStatus)
+	switch {
+	case nodeStatus.Phase == microk8sv1alpha1.UpgradePhaseFailed:
+		log.Info("node upgrade failed", "message", nodeStatus.Message)
+		upgrade.Status.Phase = microk8sv1alpha1.UpgradePhaseFailed
+		upgrade.Status.Message = fmt.Sprintf("upgrade halted, node %s failed: %s", nodeStatus.Name, nodeStatus.Message)
+	case isLastNode(upgrade, nodeStatus):
+		upgrade.Status.Phase = microk8sv1alpha1.UpgradePhaseCompleted
+		upgrade.Status.Message = ""
+		upgrade.Status.CurrentNode = ""
+		log.Info("upgrade completed")
+	}
+	if err := r.Client.Status().Update(ctx, upgrade); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update upgrade status: %w", err)
+	}
+	return result, nil
+}
+
+// isLastNode returns true if nodeStatus is completed and is the last node of the upgrade.
+func isLastNode(upgrade *microk8sv1alpha1.MicroK8sUpgrade, nodeStatus *microk8sv1alpha1.MicroK8sUpgradeNodeStatus) bool {
+	if nodeStatus.Phase != microk8sv1alpha1.UpgradePhaseCompleted {
+		return false
+	}
+	for _, n := range upgrade.Status.Nodes {
+		if n.Phase != microk8sv1alpha1.UpgradePhaseCompleted {
+			return false
+		}
+	}
+	return true
+}
+
+// planNodes returns the list of nodes to upgrade, control plane nodes first.
+// Only nodes with a running node agent (i.e. with a MicroK8sNode object) are included.
+func (r *Reconciler) planNodes(ctx context.Context) ([]microk8sv1alpha1.MicroK8sUpgradeNodeStatus, error) {
+	microk8sNodes := &microk8sv1alpha1.MicroK8sNodeList{}
+	if err := r.Client.List(ctx, microk8sNodes); err != nil {
+		return nil, fmt.Errorf("failed to list microk8s nodes: %w", err)
+	}
+
+	nodes := make([]microk8sv1alpha1.MicroK8sUpgradeNodeStatus, 0, len(microk8sNodes.Items))
+	for _, microk8sNode := range microk8sNodes.Items {
+		node := &corev1.Node{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: microk8sNode.Name}, node); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get node %s: %w", microk8sNode.Name, err)
+		}
+		nodes = append(nodes, microk8sv1alpha1.MicroK8sUpgradeNodeStatus{
+			Name:         node.Name,
+			ControlPlane: nodeutil.IsControlPlane(node),
+			Phase:        microk8sv1alpha1.UpgradePhasePending,
+		})
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].ControlPlane != nodes[j].ControlPlane {
+			return nodes[i].ControlPlane
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes, nil
+}
+
+// upgradeNode performs the next upgrade step for the current node and updates nodeStatus accordingly.
+func (r *Reconciler) upgradeNode(ctx context.Context, upgrade *microk8sv1alpha1.MicroK8sUpgrade, nodeStatus *microk8sv1alpha1.MicroK8sUpgradeNodeStatus) ctrl.Result {
+	log := log.FromContext(ctx).WithValues("node", nodeStatus.Name)
+
+	drainer := &nodeutil.Drainer{Clientset: r.Clientset}
+
+	fail := func(err error) ctrl.Result {
+		nodeStatus.Phase = microk8sv1alpha1.UpgradePhaseFailed
+		nodeStatus.Message = err.Error()
+		return ctrl.Result{}
+	}
+
+	switch nodeStatus.Phase {
+	case microk8sv1alpha1.UpgradePhasePending:
+		if err := r.checkVersionSkew(ctx, upgrade, nodeStatus.Name); err != nil {
+			return fail(fmt.Errorf("refusing to refresh node: %w", err))
+		}
+		now := metav1.Now()
+		nodeStatus.StartTime = &now
+		nodeStatus.Phase = microk8sv1alpha1.UpgradePhaseDraining
+		nodeStatus.Message = "cordoning and draining node"
+
+	case microk8sv1alpha1.UpgradePhaseDraining:
+		if err := drainer.Cordon(ctx, nodeStatus.Name); err != nil {
+			return fail(fmt.Errorf("failed to cordon node: %w", err))
+		}
+		// pods are evicted one pass per reconcile, so that draining does not block the controller
+		remaining, err := drainer.Evict(ctx, nodeStatus.Name)
+		if err != nil {
+			return fail(fmt.Errorf("failed to drain node: %w", err))
+		}
+		if remaining > 0 {
+			drainTimeout := durationOrDefault(upgrade.Spec.DrainTimeout, defaultDrainTimeout)
+			if drainTimeout > 0 && nodeStatus.StartTime != nil && time.Since(nodeStatus.StartTime.Time) > drainTimeout {
+				return fail(fmt.Errorf("timed out waiting for %d pods to be evicted", remaining))
+			}
+			nodeStatus.Message = fmt.Sprintf("waiting for %d pods to be evicted", remaining)
+			return ctrl.Result{RequeueAfter: drainPollInterval}
+		}
+		log.Info("drained node")
+		nodeStatus.Phase = microk8sv1alpha1.UpgradePhaseRefreshing
+		nodeStatus.Message = "refreshing microk8s snap"
+
+	case microk8sv1alpha1.UpgradePhaseRefreshing:
+		// the refresh is started once and checked on in later reconciles, so that it does not block the controller
+		if nodeStatus.RefreshStartTime == nil {
+			changeID, err := r.RefreshSnap(ctx, upgrade.Spec.Channel, upgrade.Spec.Revision)
+			if err != nil {
+				return fail(fmt.Errorf("failed to refresh microk8s snap: %w", err))
+			}
+			now := metav1.Now()
+			nodeStatus.RefreshStartTime = &now
+			nodeStatus.RefreshChange = changeID
+		}
+		if nodeStatus.RefreshChange != "" {
+			done, err := r.RefreshDone(ctx, nodeStatus.RefreshChange)
+			if err != nil {
+				return fail(fmt.Errorf("failed to refresh microk8s snap: %w", err))
+			}
+			if !done {
+				if time.Since(nodeStatus.RefreshStartTime.Time) > refreshTimeout {
+					return fail(fmt.Errorf("timed out waiting for snapd change %s", nodeStatus.RefreshChange))
+				}
+				nodeStatus.Message = fmt.Sprintf("waiting for snapd change %s", nodeStatus.RefreshChange)
+				return ctrl.Result{RequeueAfter: refreshPollInterval}
+			}
+		}
+		snapInfo, err := r.SnapInfo(ctx)
+		if err != nil {
+			return fail(fmt.Errorf("failed to retrieve microk8s snap info: %w", err))
+		}
+		log.Info("refreshed microk8s snap", "version", snapInfo.Version, "revision", snapInfo.Revision)
+		now := metav1.Now()
+		nodeStatus.Version = snapInfo.Version
+		nodeStatus.RefreshTime = &now
+		nodeStatus.Phase = microk8sv1alpha1.UpgradePhaseWaitingReady
+		nodeStatus.Message = fmt.Sprintf("waiting for node to become ready with version %s", snapInfo.Version)
+
+	case microk8sv1alpha1.UpgradePhaseWaitingReady:
+		ready, err := r.isNodeReady(ctx, nodeStatus.Name, nodeStatus.Version)
+		if err != nil {
+			log.Error(err, "failed to check node readiness")
+		}
+		if !ready {
+			readyTimeout := durationOrDefault(upgrade.Spec.ReadyTimeout, defaultReadyTimeout)
+			if nodeStatus.RefreshTime != nil && time.Since(nodeStatus.RefreshTime.Time) > readyTimeout {
+				return fail(fmt.Errorf("timed out waiting for node to become ready with version %s", nodeStatus.Version))
+			}
+			return ctrl.Result{RequeueAfter: readyPollInterval}
+		}
+		if err := drainer.Uncordon(ctx, nodeStatus.Name); err != nil {
+			return fail(fmt.Errorf("failed to uncordon node: %w", err))
+		}
+		log.Info("node upgrade completed")
+		now := metav1.Now()
+		nodeStatus.CompletionTime = &now
+		nodeStatus.Phase = microk8sv1alpha1.UpgradePhaseCompleted
+		nodeStatus.Message = ""
+	}
+
+	return ctrl.Result{}
+}
+
+// checkVersionSkew returns an error if refreshing the node to the target channel or revision would violate the
+// version skew policy of the cluster. If the target does not include a Kubernetes version (e.g. "latest/edge" or a
+// revision), the version is resolved from the snap store. Targets with an unknown version are refused, unless the
+// upgrade explicitly allows them.
+func (r *Reconciler) checkVersionSkew(ctx context.Context, upgrade *microk8sv1alpha1.MicroK8sUpgrade, name string) error {
+	target, err := r.targetVersion(ctx, upgrade.Spec.Channel, upgrade.Spec.Revision)
+	if err != nil {
+		if upgrade.Spec.AllowUnknownVersion {
+			log.FromContext(ctx).Info("skipping version skew check", "reason", err.Error())
+			return nil
+		}
+		return fmt.Errorf("%w, set allowUnknownVersion to refresh anyway", err)
+	}
+	nodes, err := skew.ClusterVersions(ctx, r.Client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve cluster versions: %w", err)
+	}
+	for i := range nodes {
+		if nodes[i].Name == name {
+			nodes[i].Version = target
+		}
+	}
+	if err := skew.CheckAll(nodes); err != nil {
+		return fmt.Errorf("refresh to %s would violate the version skew policy: %w", target, err)
+	}
+	return nil
+}
+
+// targetVersion returns the Kubernetes version of the channel or revision to refresh to.
+func (r *Reconciler) targetVersion(ctx context.Context, channel, revision string) (skew.Version, error) {
+	if revision == "" {
+		if target, err := skew.ParseVersion("", channel); err == nil {
+			return target, nil
+		}
+	}
+	var version string
+	if r.ResolveVersion != nil {
+		resolved, err := r.ResolveVersion(ctx, channel, revision)
+		if err != nil {
+			return skew.Version{}, fmt.Errorf("failed to resolve version from the snap store: %w", err)
+		}
+		version = resolved
+	}
+	if version == "" {
+		return skew.Version{}, fmt.Errorf("could not determine the kubernetes version of channel %q revision %q", channel, revision)
+	}
+	return skew.ParseVersion(version, "")
+}
+
+// isNodeReady returns true if the node is Ready and its MicroK8sNode reports the expected version.
+func (r *Reconciler) isNodeReady(ctx context.Context, name string, version string) (bool, error) {
+	microk8sNode := &microk8sv1alpha1.MicroK8sNode{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: name}, microk8sNode); err != nil {
+		return false, fmt.Errorf("failed to get microk8s node: %w", err)
+	}
+	if microk8sNode.Status.Version != version {
+		return false, nil
+	}
+	node := &corev1.Node{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: name}, node); err != nil {
+		return false, fmt.Errorf("failed to get node: %w", err)
+	}
+	return nodeutil.IsReady(node), nil
+}
+
+func durationOrDefault(d *metav1.Duration, defaultDuration time.Duration) time.Duration {
+	if d == nil {
+		return defaultDuration
+	}
+	return d.Duration
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&microk8sv1alpha1.MicroK8sUpgrade{}).
+		Complete(r)
+}
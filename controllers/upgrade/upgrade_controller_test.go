@@ -0,0 +1,195 @@
+package upgrade
+
+import (
+	"context"
+	"testing"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/controllers/microk8snode"
+	"github.com/neoaggelos/microk8s-operator/pkg/nodeutil"
+	"github.com/neoaggelos/microk8s-operator/pkg/skew"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPlanNodes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = microk8sv1alpha1.AddToScheme(scheme)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{nodeutil.WorkerLabel: ""}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cp-2", Labels: map[string]string{nodeutil.ControlPlaneLabel: ""}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cp-1", Labels: map[string]string{nodeutil.ControlPlaneLabel: ""}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "no-agent"}},
+		&microk8sv1alpha1.MicroK8sNode{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}},
+		&microk8sv1alpha1.MicroK8sNode{ObjectMeta: metav1.ObjectMeta{Name: "cp-2"}},
+		&microk8sv1alpha1.MicroK8sNode{ObjectMeta: metav1.ObjectMeta{Name: "cp-1"}},
+		&microk8sv1alpha1.MicroK8sNode{ObjectMeta: metav1.ObjectMeta{Name: "deleted-node"}},
+	).Build()
+
+	r := &Reconciler{Client: c}
+	nodes, err := r.planNodes(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error planning nodes but received %q", err)
+	}
+
+	expected := []string{"cp-1", "cp-2", "worker-1"}
+	if len(nodes) != len(expected) {
+		t.Fatalf("Expected %d nodes but got %d instead", len(expected), len(nodes))
+	}
+	for i, name := range expected {
+		if nodes[i].Name != name {
+			t.Fatalf("Expected node %d to be %q but it was %q instead", i, name, nodes[i].Name)
+		}
+		if nodes[i].Phase != microk8sv1alpha1.UpgradePhasePending {
+			t.Fatalf("Expected node %q to be %q but it was %q instead", name, microk8sv1alpha1.UpgradePhasePending, nodes[i].Phase)
+		}
+	}
+	if !nodes[0].ControlPlane || nodes[2].ControlPlane {
+		t.Fatalf("Expected control plane nodes to be marked as such")
+	}
+}
+
+func TestReconcilePlansOnFirstNode(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = microk8sv1alpha1.AddToScheme(scheme)
+
+	for _, tc := range []struct {
+		node  string
+		phase string
+	}{
+		{node: "worker-1", phase: ""},
+		{node: "cp-1", phase: microk8sv1alpha1.UpgradePhaseInProgress},
+	} {
+		t.Run(tc.node, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}},
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cp-1", Labels: map[string]string{nodeutil.ControlPlaneLabel: ""}}},
+				&microk8sv1alpha1.MicroK8sNode{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}},
+				&microk8sv1alpha1.MicroK8sNode{ObjectMeta: metav1.ObjectMeta{Name: "cp-1"}},
+				&microk8sv1alpha1.MicroK8sUpgrade{ObjectMeta: metav1.ObjectMeta{Name: "upgrade"}, Spec: microk8sv1alpha1.MicroK8sUpgradeSpec{Channel: "1.25/stable"}},
+			).Build()
+
+			r := &Reconciler{Client: c, Node: tc.node}
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "upgrade"}}); err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+			upgrade := &microk8sv1alpha1.MicroK8sUpgrade{}
+			if err := c.Get(context.Background(), types.NamespacedName{Name: "upgrade"}, upgrade); err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+			if upgrade.Status.Phase != tc.phase {
+				t.Fatalf("Expected upgrade phase to be %q but it was %q instead", tc.phase, upgrade.Status.Phase)
+			}
+		})
+	}
+}
+
+func TestReconcileFailsWithoutNodes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = microk8sv1alpha1.AddToScheme(scheme)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&microk8sv1alpha1.MicroK8sUpgrade{ObjectMeta: metav1.ObjectMeta{Name: "upgrade"}, Spec: microk8sv1alpha1.MicroK8sUpgradeSpec{Channel: "1.25/stable"}},
+	).Build()
+
+	r := &Reconciler{Client: c, Node: "node"}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "upgrade"}}); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	upgrade := &microk8sv1alpha1.MicroK8sUpgrade{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "upgrade"}, upgrade); err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	if upgrade.Status.Phase != microk8sv1alpha1.UpgradePhaseFailed {
+		t.Fatalf("Expected upgrade phase to be %q but it was %q instead", microk8sv1alpha1.UpgradePhaseFailed, upgrade.Status.Phase)
+	}
+}
+
+func TestUpgradeNodeRefreshing(t *testing.T) {
+	var refreshes int
+	done := false
+	r := &Reconciler{
+		RefreshSnap: func(ctx context.Context, channel, revision string) (string, error) {
+			refreshes++
+			return "42", nil
+		},
+		RefreshDone: func(ctx context.Context, changeID string) (bool, error) {
+			if changeID != "42" {
+				t.Fatalf("Expected change 42 but got %q", changeID)
+			}
+			return done, nil
+		},
+		SnapInfo: func(ctx context.Context) (microk8snode.SnapInfo, error) {
+			return microk8snode.SnapInfo{Version: "v1.25.2"}, nil
+		},
+	}
+	upgrade := &microk8sv1alpha1.MicroK8sUpgrade{Spec: microk8sv1alpha1.MicroK8sUpgradeSpec{Channel: "1.25/stable"}}
+	nodeStatus := &microk8sv1alpha1.MicroK8sUpgradeNodeStatus{Name: "node", Phase: microk8sv1alpha1.UpgradePhaseRefreshing}
+
+	for i := 0; i < 2; i++ {
+		if result := r.upgradeNode(context.Background(), upgrade, nodeStatus); result.RequeueAfter == 0 {
+			t.Fatalf("Expected requeue while the refresh is in progress on pass %d", i)
+		}
+		if nodeStatus.Phase != microk8sv1alpha1.UpgradePhaseRefreshing || nodeStatus.RefreshChange != "42" {
+			t.Fatalf("Expected node to be refreshing with change 42 but got %q with change %q", nodeStatus.Phase, nodeStatus.RefreshChange)
+		}
+	}
+	done = true
+	r.upgradeNode(context.Background(), upgrade, nodeStatus)
+	if nodeStatus.Phase != microk8sv1alpha1.UpgradePhaseWaitingReady || nodeStatus.Version != "v1.25.2" {
+		t.Fatalf("Expected node to be waiting for version v1.25.2 but got %q with version %q", nodeStatus.Phase, nodeStatus.Version)
+	}
+	if refreshes != 1 {
+		t.Fatalf("Expected the refresh to be started once but it was started %d times", refreshes)
+	}
+}
+
+func TestTargetVersion(t *testing.T) {
+	r := &Reconciler{
+		ResolveVersion: func(ctx context.Context, channel, revision string) (string, error) {
+			switch {
+			case revision == "4000":
+				return "v1.26.1", nil
+			case revision == "" && channel == "latest/edge":
+				return "v1.27.0", nil
+			}
+			return "", nil
+		},
+	}
+	for _, tc := range []struct {
+		channel  string
+		revision string
+		expected skew.Version
+		err      bool
+	}{
+		{channel: "1.25/stable", expected: skew.Version{Major: 1, Minor: 25}},
+		{channel: "latest/edge", expected: skew.Version{Major: 1, Minor: 27}},
+		{channel: "1.25/stable", revision: "4000", expected: skew.Version{Major: 1, Minor: 26}},
+		{revision: "1234", err: true},
+	} {
+		t.Run(tc.channel+"@"+tc.revision, func(t *testing.T) {
+			v, err := r.targetVersion(context.Background(), tc.channel, tc.revision)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("Expected an error but received none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+			if v != tc.expected {
+				t.Fatalf("Expected version %v but it was %v instead", tc.expected, v)
+			}
+		})
+	}
+}
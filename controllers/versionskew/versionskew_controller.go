@@ -0,0 +1,115 @@
+/*
+Copyright 2022 Angelos Kolaitis.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package versionskew
+
+import (
+	"context"
+	"fmt"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/skew"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Reconciler checks the version skew of the local node against the control plane nodes of the cluster.
+// The result is published as the VersionSkew condition of the MicroK8sNode, along with an Event.
+type Reconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Node information
+	Node string
+}
+
+//+kubebuilder:rbac:groups=microk8s.canonical.com,resources=microk8snodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=microk8s.canonical.com,resources=microk8snodes/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile updates the VersionSkew condition of the local node.
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	nodes, err := skew.ClusterVersions(ctx, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	node := &microk8sv1alpha1.MicroK8sNode{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: r.Node}, node); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	condition := metav1.Condition{
+		Type:               microk8sv1alpha1.ConditionVersionSkew,
+		Status:             metav1.ConditionFalse,
+		Reason:             "SupportedVersionSkew",
+		Message:            "node is within the supported version skew of the control plane",
+		ObservedGeneration: node.Generation,
+	}
+	if err := skew.CheckNode(nodes, r.Node); err != nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "UnsupportedVersionSkew"
+		condition.Message = err.Error()
+	}
+
+	existing := meta.FindStatusCondition(node.Status.Conditions, condition.Type)
+	if existing != nil && existing.Status == condition.Status && existing.Message == condition.Message {
+		return ctrl.Result{}, nil
+	}
+	wasViolating := existing != nil && existing.Status == metav1.ConditionTrue
+
+	meta.SetStatusCondition(&node.Status.Conditions, condition)
+	if err := r.Client.Status().Update(ctx, node); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update node status: %w", err)
+	}
+
+	if condition.Status == metav1.ConditionTrue {
+		log.Info("node violates the version skew policy", "message", condition.Message)
+		r.Recorder.Event(node, corev1.EventTypeWarning, condition.Reason, condition.Message)
+	} else if wasViolating {
+		log.Info("node is within the supported version skew")
+		r.Recorder.Event(node, corev1.EventTypeNormal, condition.Reason, condition.Message)
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// any change to a node in the cluster may affect the version skew of the local node
+	enqueueLocalNode := handler.EnqueueRequestsFromMapFunc(func(client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: r.Node}}}
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("versionskew").
+		Watches(&source.Kind{Type: &microk8sv1alpha1.MicroK8sNode{}}, enqueueLocalNode).
+		Watches(&source.Kind{Type: &corev1.Node{}}, enqueueLocalNode).
+		Complete(r)
+}
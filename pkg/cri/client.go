@@ -0,0 +1,75 @@
+// Package cri implements a client for the CRI image service of containerd.
+//
+// Only the few image service methods needed by the node agent are exposed.
+package cri
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// Image is an image known to the container runtime.
+type Image struct {
+	ID          string
+	RepoTags    []string
+	RepoDigests []string
+	Size        uint64
+}
+
+// Client is a client for the CRI image service.
+type Client struct {
+	images runtimeapi.ImageServiceClient
+}
+
+// New returns a client for the CRI image service listening on the unix socket.
+// The connection is established on the first call.
+func New(socket string) (*Client, error) {
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRI client: %w", err)
+	}
+	return &Client{images: runtimeapi.NewImageServiceClient(conn)}, nil
+}
+
+// PullImage pulls an image and returns its reference.
+func (c *Client) PullImage(ctx context.Context, image string) (string, error) {
+	response, err := c.images.PullImage(ctx, &runtimeapi.PullImageRequest{Image: &runtimeapi.ImageSpec{Image: image}})
+	if err != nil {
+		return "", err
+	}
+	return response.ImageRef, nil
+}
+
+// ImageStatus returns the image, or nil if it is not present.
+func (c *Client) ImageStatus(ctx context.Context, image string) (*Image, error) {
+	response, err := c.images.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{Image: &runtimeapi.ImageSpec{Image: image}})
+	if err != nil {
+		return nil, err
+	}
+	if response.Image == nil {
+		return nil, nil
+	}
+	img := newImage(response.Image)
+	return &img, nil
+}
+
+// ListImages returns all images.
+func (c *Client) ListImages(ctx context.Context) ([]Image, error) {
+	response, err := c.images.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	images := make([]Image, 0, len(response.Images))
+	for _, img := range response.Images {
+		images = append(images, newImage(img))
+	}
+	return images, nil
+}
+
+func newImage(img *runtimeapi.Image) Image {
+	return Image{ID: img.Id, RepoTags: img.RepoTags, RepoDigests: img.RepoDigests, Size: img.Size_}
+}
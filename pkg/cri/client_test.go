@@ -0,0 +1,71 @@
+package cri
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+type fakeImageService struct {
+	runtimeapi.UnimplementedImageServiceServer
+	images []*runtimeapi.Image
+}
+
+func (s *fakeImageService) ListImages(ctx context.Context, req *runtimeapi.ListImagesRequest) (*runtimeapi.ListImagesResponse, error) {
+	return &runtimeapi.ListImagesResponse{Images: s.images}, nil
+}
+
+func (s *fakeImageService) ImageStatus(ctx context.Context, req *runtimeapi.ImageStatusRequest) (*runtimeapi.ImageStatusResponse, error) {
+	for _, img := range s.images {
+		for _, tag := range img.RepoTags {
+			if tag == req.Image.Image {
+				return &runtimeapi.ImageStatusResponse{Image: img}, nil
+			}
+		}
+	}
+	return &runtimeapi.ImageStatusResponse{}, nil
+}
+
+func TestClient(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "containerd.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Expected no error listening on socket but received %q", err)
+	}
+	server := grpc.NewServer()
+	runtimeapi.RegisterImageServiceServer(server, &fakeImageService{images: []*runtimeapi.Image{
+		{Id: "sha256:1234", RepoTags: []string{"docker.io/library/nginx:latest"}, Size_: 1024},
+	}})
+	go server.Serve(l)
+	defer server.Stop()
+
+	c, err := New(socket)
+	if err != nil {
+		t.Fatalf("Expected no error creating client but received %q", err)
+	}
+	expected := Image{ID: "sha256:1234", RepoTags: []string{"docker.io/library/nginx:latest"}, Size: 1024}
+
+	images, err := c.ListImages(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error listing images but received %q", err)
+	}
+	if !reflect.DeepEqual(images, []Image{expected}) {
+		t.Fatalf("Expected images %#v but got %#v", []Image{expected}, images)
+	}
+
+	img, err := c.ImageStatus(context.Background(), "docker.io/library/nginx:latest")
+	if err != nil {
+		t.Fatalf("Expected no error getting image status but received %q", err)
+	}
+	if img == nil || !reflect.DeepEqual(*img, expected) {
+		t.Fatalf("Expected image %#v but got %#v", expected, img)
+	}
+	if img, err := c.ImageStatus(context.Background(), "docker.io/library/missing:latest"); err != nil || img != nil {
+		t.Fatalf("Expected missing image to be nil but got %#v (err=%v)", img, err)
+	}
+}
@@ -0,0 +1,156 @@
+package hostfile
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// backupTimeFormat is the format of the timestamp suffix of backup files.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// Backup is a copy of a file before it was overwritten.
+type Backup struct {
+	// File is the path of the original file.
+	File string
+	// Path is the path of the backup.
+	Path string
+	// Time is the time the backup was taken.
+	Time time.Time
+}
+
+// Backups keeps timestamped copies of files before they are overwritten.
+// The backups of a file are stored in Dir, under the full path of the file.
+type Backups struct {
+	// Dir is the directory where backups are stored.
+	Dir string
+	// Retention is the number of backups to keep for each file.
+	Retention int
+}
+
+// Save backs up the current contents of file, and removes its oldest backups so that at most Retention remain.
+// It does nothing if the file does not exist.
+func (b Backups) Save(file string) error {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	base := b.base(file)
+	if err := os.MkdirAll(filepath.Dir(base), 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	path := fmt.Sprintf("%s.%s", base, time.Now().UTC().Format(backupTimeFormat))
+	if err := WriteAtomic(path, contents, 0600); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return b.prune(file)
+}
+
+// Remove removes all backups of file.
+func (b Backups) Remove(file string) error {
+	matches, err := filepath.Glob(b.base(file) + ".*")
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	for _, match := range matches {
+		if base, _, ok := parseBackupPath(match); !ok || base != b.base(file) {
+			continue
+		}
+		if err := os.Remove(match); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// List returns all backups, sorted by file and then by time, newest first.
+func (b Backups) List() ([]Backup, error) {
+	var backups []Backup
+	err := filepath.WalkDir(b.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || IsTemporary(path) {
+			return nil
+		}
+		base, t, ok := parseBackupPath(path)
+		if !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(b.Dir, base)
+		if err != nil {
+			return nil
+		}
+		backups = append(backups, Backup{File: string(filepath.Separator) + rel, Path: path, Time: t})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].File != backups[j].File {
+			return backups[i].File < backups[j].File
+		}
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// base returns the path of the backups of file, without the timestamp suffix.
+func (b Backups) base(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	return filepath.Join(b.Dir, abs)
+}
+
+// prune removes the oldest backups of file, so that at most Retention remain.
+func (b Backups) prune(file string) error {
+	base := b.base(file)
+	matches, err := filepath.Glob(base + ".*")
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	var backups []string
+	for _, match := range matches {
+		if matchBase, _, ok := parseBackupPath(match); ok && matchBase == base {
+			backups = append(backups, match)
+		}
+	}
+	if len(backups) <= b.Retention {
+		return nil
+	}
+	// the timestamp format sorts lexicographically
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-b.Retention] {
+		if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseBackupPath splits the path of a backup into the path without the timestamp suffix and the timestamp.
+// It returns false if path is not a backup.
+func parseBackupPath(path string) (string, time.Time, bool) {
+	// the timestamp has a fixed length, and may itself contain a dot
+	idx := len(path) - len(backupTimeFormat) - 1
+	if idx <= 0 || path[idx] != '.' {
+		return "", time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeFormat, path[idx+1:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return path[:idx], t, true
+}
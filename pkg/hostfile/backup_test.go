@@ -0,0 +1,73 @@
+package hostfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackups(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "args", "kubelet")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("Expected no error setting up directory but received %q", err)
+	}
+	backups := Backups{Dir: filepath.Join(dir, "backups"), Retention: 2}
+
+	for _, contents := range []string{"first", "second", "third", "fourth"} {
+		if err := backups.Save(file); err != nil {
+			t.Fatalf("Expected no error saving backup but received %q", err)
+		}
+		if err := WriteAtomic(file, []byte(contents), 0660); err != nil {
+			t.Fatalf("Expected no error writing file but received %q", err)
+		}
+	}
+
+	if b, err := os.ReadFile(file); err != nil || string(b) != "fourth" {
+		t.Fatalf("Expected file contents %q but got %q (err=%v)", "fourth", string(b), err)
+	}
+	list, err := backups.List()
+	if err != nil {
+		t.Fatalf("Expected no error listing backups but received %q", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 backups but got %d: %v", len(list), list)
+	}
+	for i, expected := range []string{"third", "second"} {
+		if list[i].File != file {
+			t.Fatalf("Expected backup of %q but got %q", file, list[i].File)
+		}
+		if b, err := os.ReadFile(list[i].Path); err != nil || string(b) != expected {
+			t.Fatalf("Expected backup %d to contain %q but got %q (err=%v)", i, expected, string(b), err)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(file)); len(entries) != 1 {
+		t.Fatalf("Expected no temporary files to be left behind but got %d entries", len(entries))
+	}
+}
+
+func TestBackupsRemove(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "encryption-config.yaml")
+	other := filepath.Join(dir, "encryption-config.yaml.orig")
+	backups := Backups{Dir: filepath.Join(dir, "backups"), Retention: 2}
+	for _, f := range []string{file, other} {
+		if err := os.WriteFile(f, []byte("contents"), 0600); err != nil {
+			t.Fatalf("Expected no error setting up file but received %q", err)
+		}
+		if err := backups.Save(f); err != nil {
+			t.Fatalf("Expected no error saving backup but received %q", err)
+		}
+	}
+
+	if err := backups.Remove(file); err != nil {
+		t.Fatalf("Expected no error removing backups but received %q", err)
+	}
+	list, err := backups.List()
+	if err != nil {
+		t.Fatalf("Expected no error listing backups but received %q", err)
+	}
+	if len(list) != 1 || list[0].File != other {
+		t.Fatalf("Expected only the backup of %q to remain but got %v", other, list)
+	}
+}
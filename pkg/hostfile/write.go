@@ -0,0 +1,70 @@
+package hostfile
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// tempPrefix is the prefix of temporary files created while writing. Watchers should ignore these.
+const tempPrefix = ".operator-tmp-"
+
+// IsTemporary returns true if path is a temporary file created by WriteAtomic.
+func IsTemporary(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), tempPrefix)
+}
+
+// WriteAtomic writes contents to file, such that readers see either the old or the new contents, but never a
+// partially written file. The contents are written to a temporary file in the same directory, which is synced
+// and then renamed over file. If file already exists, its mode, owner and group are preserved, and perm is only
+// used for new files.
+func WriteAtomic(file string, contents []byte, perm fs.FileMode) error {
+	dir := filepath.Dir(file)
+	tmp, err := os.CreateTemp(dir, tempPrefix+filepath.Base(file)+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	// no-op after a successful rename
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	info, statErr := os.Stat(file)
+	if statErr == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if statErr == nil {
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err := tmp.Chown(int(st.Uid), int(st.Gid)); err != nil {
+				tmp.Close()
+				return fmt.Errorf("failed to set file owner: %w", err)
+			}
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+
+	// sync the directory, so that the rename is persisted
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
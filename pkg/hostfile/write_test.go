@@ -0,0 +1,33 @@
+package hostfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAtomicMode(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "new")
+	if err := WriteAtomic(file, []byte("contents"), 0640); err != nil {
+		t.Fatalf("Expected no error writing file but received %q", err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0640 {
+		t.Fatalf("Expected new file to have mode 0640 but got %v (err=%v)", info.Mode().Perm(), err)
+	}
+
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, []byte("old"), 0600); err != nil {
+		t.Fatalf("Expected no error setting up file but received %q", err)
+	}
+	if err := os.Chmod(existing, 0644); err != nil {
+		t.Fatalf("Expected no error setting up file but received %q", err)
+	}
+	if err := WriteAtomic(existing, []byte("new"), 0600); err != nil {
+		t.Fatalf("Expected no error writing file but received %q", err)
+	}
+	if info, err := os.Stat(existing); err != nil || info.Mode().Perm() != 0644 {
+		t.Fatalf("Expected existing file to keep mode 0644 but got %v (err=%v)", info.Mode().Perm(), err)
+	}
+}
@@ -0,0 +1,89 @@
+// Package metrics defines the Prometheus metrics of the operator.
+// Metrics are registered with the controller-runtime registry, and are served on the manager metrics endpoint.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "microk8s_operator"
+
+const (
+	// OutcomeSuccess is the outcome label value for successful operations.
+	OutcomeSuccess = "success"
+	// OutcomeFailure is the outcome label value for failed operations.
+	OutcomeFailure = "failure"
+)
+
+var (
+	// FileUpdates counts updates of managed host files.
+	FileUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "file_updates_total",
+		Help:      "Number of updates of managed host files.",
+	}, []string{"file"})
+
+	// ServiceRestarts counts restarts of snap services.
+	ServiceRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_restarts_total",
+		Help:      "Number of snap service restarts.",
+	}, []string{"service", "outcome"})
+
+	// ServiceRestartDuration observes how long snap service restarts take.
+	ServiceRestartDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "service_restart_duration_seconds",
+		Help:      "Duration of snap service restarts in seconds.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 20, 30, 60},
+	}, []string{"service", "outcome"})
+
+	// CertificateRefreshes counts refreshes of the server certificates.
+	CertificateRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "certificate_refreshes_total",
+		Help:      "Number of server certificate refreshes.",
+	}, []string{"outcome"})
+
+	// AddonRepositoryFetches counts fetches of addon repositories.
+	AddonRepositoryFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "addon_repository_fetches_total",
+		Help:      "Number of addon repository fetches.",
+	}, []string{"repository", "outcome"})
+
+	// LastSuccessfulApply is the timestamp of the last time the configuration was applied without errors.
+	LastSuccessfulApply = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_apply_timestamp_seconds",
+		Help:      "Unix timestamp of the last time the configuration was applied on the node without errors.",
+	}, []string{"node"})
+
+	// NodeConfiguredAfter is how long after joining the cluster the configuration was first applied on a node.
+	NodeConfiguredAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_configured_after_seconds",
+		Help:      "Seconds between the node joining the cluster and the configuration being first applied on it.",
+	}, []string{"node"})
+)
+
+// Outcome returns the outcome label value for an error.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+func init() {
+	metrics.Registry.MustRegister(
+		FileUpdates,
+		ServiceRestarts,
+		ServiceRestartDuration,
+		CertificateRefreshes,
+		AddonRepositoryFetches,
+		LastSuccessfulApply,
+		NodeConfiguredAfter,
+	)
+}
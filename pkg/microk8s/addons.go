@@ -0,0 +1,83 @@
+package microk8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+type addonsManifest struct {
+	Addons struct {
+		Addons []struct {
+			Name        string `json:"name"`
+			CheckStatus string `json:"check_status"`
+		} `json:"addons"`
+	} `json:"microk8s-addons"`
+}
+
+// EnabledAddons returns the enabled addons of all repositories in addonsDir, as "repository/addon".
+//
+// An addon is enabled if its status check passes, like in "microk8s status". Status checks that are paths are
+// expanded with expand and checked on the node. Other status checks are matched against the names of the
+// Kubernetes resources returned by resources, formatted as "kind.group/name". resources is only called if needed.
+func EnabledAddons(ctx context.Context, addonsDir string, expand func(string) string, resources func(ctx context.Context) ([]string, error)) ([]string, error) {
+	repos, err := os.ReadDir(addonsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addon repositories: %w", err)
+	}
+
+	var enabled []string
+	var names []string
+	var loaded bool
+	for _, repo := range repos {
+		if !repo.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(addonsDir, repo.Name(), "addons.yaml"))
+		if err != nil {
+			continue
+		}
+		var manifest addonsManifest
+		if err := yaml.Unmarshal(b, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse addons of repository %s: %w", repo.Name(), err)
+		}
+		for _, addon := range manifest.Addons.Addons {
+			check := addon.CheckStatus
+			switch {
+			case check == "":
+				continue
+			case strings.HasPrefix(check, "/") || strings.HasPrefix(check, "$"):
+				if _, err := os.Stat(os.Expand(check, expand)); err != nil {
+					continue
+				}
+			default:
+				if !loaded {
+					if names, err = resources(ctx); err != nil {
+						return nil, fmt.Errorf("failed to list resources: %w", err)
+					}
+					loaded = true
+				}
+				if !containsSubstring(names, check) {
+					continue
+				}
+			}
+			enabled = append(enabled, fmt.Sprintf("%s/%s", repo.Name(), addon.Name))
+		}
+	}
+	sort.Strings(enabled)
+	return enabled, nil
+}
+
+func containsSubstring(items []string, substr string) bool {
+	for _, item := range items {
+		if strings.Contains(item, substr) {
+			return true
+		}
+	}
+	return false
+}
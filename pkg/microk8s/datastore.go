@@ -0,0 +1,67 @@
+// Package microk8s inspects the local MicroK8s installation.
+package microk8s
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Datastore roles of a node.
+const (
+	RoleVoter   = "Voter"
+	RoleStandBy = "StandBy"
+	RoleSpare   = "Spare"
+	RoleWorker  = "Worker"
+)
+
+// dqliteRoles maps the dqlite role numbers to names.
+var dqliteRoles = map[int]string{0: RoleVoter, 1: RoleStandBy, 2: RoleSpare}
+
+type dqliteNode struct {
+	ID      uint64 `json:"ID"`
+	Address string `json:"Address"`
+	Role    int    `json:"Role"`
+}
+
+// DatastoreRole returns the role of the node in the dqlite cluster, or RoleWorker for worker-only nodes.
+// It returns an empty string if the role cannot be determined, e.g. if the node uses an external datastore.
+func DatastoreRole(snapData string) (string, error) {
+	backend := filepath.Join(snapData, "var", "kubernetes", "backend")
+	b, err := os.ReadFile(filepath.Join(backend, "info.yaml"))
+	if errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(filepath.Join(snapData, "var", "lock", "clustered.lock")); err == nil {
+			return RoleWorker, nil
+		}
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read dqlite info: %w", err)
+	}
+	var info dqliteNode
+	if err := yaml.Unmarshal(b, &info); err != nil {
+		return "", fmt.Errorf("failed to parse dqlite info: %w", err)
+	}
+
+	// the role in info.yaml is only updated on restart, prefer the cluster membership if available
+	if b, err := os.ReadFile(filepath.Join(backend, "cluster.yaml")); err == nil {
+		var nodes []dqliteNode
+		if err := yaml.Unmarshal(b, &nodes); err != nil {
+			return "", fmt.Errorf("failed to parse dqlite cluster: %w", err)
+		}
+		for _, node := range nodes {
+			if node.ID == info.ID || node.Address == info.Address {
+				info.Role = node.Role
+				break
+			}
+		}
+	}
+
+	role, ok := dqliteRoles[info.Role]
+	if !ok {
+		return "", fmt.Errorf("unknown dqlite role %d", info.Role)
+	}
+	return role, nil
+}
@@ -0,0 +1,83 @@
+package microk8s
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, file, contents string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("Expected no error creating directory but received %q", err)
+	}
+	if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+		t.Fatalf("Expected no error writing file but received %q", err)
+	}
+}
+
+func TestDatastoreRole(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		role  string
+	}{
+		{name: "none"},
+		{name: "worker", files: map[string]string{"var/lock/clustered.lock": ""}, role: RoleWorker},
+		{name: "info", files: map[string]string{"var/kubernetes/backend/info.yaml": "Address: 10.0.0.1:19001\nID: 1\nRole: 1\n"}, role: RoleStandBy},
+		{name: "cluster", files: map[string]string{
+			"var/kubernetes/backend/info.yaml":    "Address: 10.0.0.1:19001\nID: 1\nRole: 2\n",
+			"var/kubernetes/backend/cluster.yaml": "- Address: 10.0.0.2:19001\n  ID: 2\n  Role: 2\n- Address: 10.0.0.1:19001\n  ID: 1\n  Role: 0\n",
+		}, role: RoleVoter},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for file, contents := range tc.files {
+				writeFile(t, filepath.Join(dir, file), contents)
+			}
+			role, err := DatastoreRole(dir)
+			if err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+			if role != tc.role {
+				t.Fatalf("Expected role %q but got %q", tc.role, role)
+			}
+		})
+	}
+}
+
+func TestEnabledAddons(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "addons", "core", "addons.yaml"), `
+microk8s-addons:
+  description: Core addons
+  addons:
+    - name: dns
+      check_status: "pod/coredns"
+    - name: ingress
+      check_status: "pod/nginx-ingress-microk8s-controller"
+    - name: host-access
+      check_status: "${SNAP_DATA}/var/lock/host-access-enabled"
+    - name: rbac
+      check_status: "${SNAP_DATA}/var/lock/rbac-enabled"
+`)
+	writeFile(t, filepath.Join(dir, "data", "var", "lock", "host-access-enabled"), "")
+
+	expand := func(key string) string {
+		if key == "SNAP_DATA" {
+			return filepath.Join(dir, "data")
+		}
+		return ""
+	}
+	resources := func(ctx context.Context) ([]string, error) {
+		return []string{"pod/coredns-12345-abcde", "deployment.apps/coredns"}, nil
+	}
+	addons, err := EnabledAddons(context.Background(), filepath.Join(dir, "addons"), expand, resources)
+	if err != nil {
+		t.Fatalf("Expected no error but received %q", err)
+	}
+	if expected := []string{"core/dns", "core/host-access"}; !reflect.DeepEqual(addons, expected) {
+		t.Fatalf("Expected addons %v but got %v", expected, addons)
+	}
+}
@@ -0,0 +1,178 @@
+package nodeutil
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// mirrorPodAnnotation is set by kubelet on pods that are created from static manifests.
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// Drainer cordons and drains Kubernetes nodes.
+type Drainer struct {
+	Clientset kubernetes.Interface
+
+	// Timeout is the maximum amount of time to wait for pods to be evicted. Zero means no timeout.
+	Timeout time.Duration
+	// PollInterval is the interval between eviction attempts. Defaults to 5 seconds.
+	PollInterval time.Duration
+}
+
+// Cordon marks a node as unschedulable.
+func (d *Drainer) Cordon(ctx context.Context, node string) error {
+	return d.setUnschedulable(ctx, node, true)
+}
+
+// Uncordon marks a node as schedulable.
+func (d *Drainer) Uncordon(ctx context.Context, node string) error {
+	return d.setUnschedulable(ctx, node, false)
+}
+
+func (d *Drainer) setUnschedulable(ctx context.Context, node string, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%v}}`, unschedulable)
+	if _, err := d.Clientset.CoreV1().Nodes().Patch(ctx, node, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to patch node: %w", err)
+	}
+	return nil
+}
+
+// Drain evicts all pods running on a node, except for DaemonSet and mirror pods.
+// Evictions go through the eviction API, so PodDisruptionBudgets are respected.
+// Evictions that are blocked by a PodDisruptionBudget are retried until Timeout expires.
+func (d *Drainer) Drain(ctx context.Context, node string) error {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	pollInterval := d.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 5 * time.Second
+	}
+
+	for {
+		remaining, err := d.Evict(ctx, node)
+		if err != nil {
+			return err
+		}
+		if remaining == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %d pods to be evicted", remaining)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Evict requests the eviction of all pods running on a node, except for DaemonSet and mirror pods,
+// and returns the number of pods that are still running. Evictions that are blocked by a
+// PodDisruptionBudget are not an error, the caller is expected to call Evict again later.
+func (d *Drainer) Evict(ctx context.Context, node string) (int, error) {
+	pods, err := d.Clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + node})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	remaining := 0
+	for _, pod := range pods.Items {
+		if !needsEviction(pod) {
+			continue
+		}
+		remaining++
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		err := d.Clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		})
+		switch {
+		case err == nil, apierrors.IsNotFound(err):
+		case apierrors.IsTooManyRequests(err):
+			// eviction is blocked by a PodDisruptionBudget, retry later
+		default:
+			return remaining, fmt.Errorf("failed to evict pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+	return remaining, nil
+}
+
+// needsEviction returns false for pods that are not affected by draining the node.
+func needsEviction(pod corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, isMirror := pod.Annotations[mirrorPodAnnotation]; isMirror {
+		return false
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
+
+// NodeLeaseNamespace is the namespace of the Lease objects that kubelet renews for each node.
+const NodeLeaseNamespace = "kube-node-lease"
+
+// WaitForReady waits until the node is Ready after since. A Ready node is accepted once kubelet has renewed
+// the node Lease after since, or once the node has been seen transitioning through NotReady after since.
+// The Ready condition heartbeat is not used, as kubelet only updates it when the node status changes.
+func (d *Drainer) WaitForReady(ctx context.Context, node string, since time.Time, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	pollInterval := d.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 5 * time.Second
+	}
+
+	var sawNotReady bool
+	for {
+		n, err := d.Clientset.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+		if err == nil {
+			if !IsReady(n) {
+				sawNotReady = true
+			} else if sawNotReady || readyTransition(n).After(since) || d.leaseRenewal(ctx, node).After(since) {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("timed out waiting for node to become ready: %w", err)
+			}
+			return fmt.Errorf("timed out waiting for node to become ready")
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// readyTransition returns the last transition time of the Ready condition of the node.
+func readyTransition(node *corev1.Node) time.Time {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
+
+// leaseRenewal returns the last time kubelet renewed the Lease of the node, or the zero time if unknown.
+func (d *Drainer) leaseRenewal(ctx context.Context, node string) time.Time {
+	lease, err := d.Clientset.CoordinationV1().Leases(NodeLeaseNamespace).Get(ctx, node, metav1.GetOptions{})
+	if err != nil || lease.Spec.RenewTime == nil {
+		return time.Time{}
+	}
+	return lease.Spec.RenewTime.Time
+}
@@ -0,0 +1,55 @@
+package nodeutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWaitForReady(t *testing.T) {
+	since := time.Now()
+	before := metav1.NewTime(since.Add(-time.Hour))
+	after := metav1.NewMicroTime(since.Add(time.Second))
+
+	node := func(transition metav1.Time) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node"},
+			Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue, LastHeartbeatTime: before, LastTransitionTime: transition},
+			}},
+		}
+	}
+	lease := func(renew metav1.MicroTime) *coordinationv1.Lease {
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Namespace: NodeLeaseNamespace},
+			Spec:       coordinationv1.LeaseSpec{RenewTime: &renew},
+		}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		objects   []runtime.Object
+		expectErr bool
+	}{
+		{name: "LeaseRenewed", objects: []runtime.Object{node(before), lease(after)}},
+		{name: "ReadyTransition", objects: []runtime.Object{node(metav1.NewTime(after.Time))}},
+		{name: "StaleLease", objects: []runtime.Object{node(before), lease(metav1.NewMicroTime(before.Time))}, expectErr: true},
+		{name: "NoLease", objects: []runtime.Object{node(before)}, expectErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &Drainer{Clientset: fake.NewSimpleClientset(tc.objects...), PollInterval: 10 * time.Millisecond}
+			err := d.WaitForReady(context.Background(), "node", since, 50*time.Millisecond)
+			if tc.expectErr && err == nil {
+				t.Fatalf("Expected an error but did not receive one")
+			} else if !tc.expectErr && err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+		})
+	}
+}
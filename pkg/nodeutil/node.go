@@ -0,0 +1,28 @@
+package nodeutil
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ControlPlaneLabel is the label MicroK8s sets on nodes that run the control plane services.
+	ControlPlaneLabel = "node.kubernetes.io/microk8s-controlplane"
+	// WorkerLabel is the label MicroK8s sets on worker-only nodes.
+	WorkerLabel = "node.kubernetes.io/microk8s-worker"
+)
+
+// IsControlPlane returns true if the node runs the MicroK8s control plane services.
+func IsControlPlane(node *corev1.Node) bool {
+	_, isControlPlane := node.Labels[ControlPlaneLabel]
+	return isControlPlane
+}
+
+// IsReady returns true if the node has a Ready condition with status True.
+func IsReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package skew
+
+import (
+	"context"
+	"fmt"
+
+	microk8sv1alpha1 "github.com/neoaggelos/microk8s-operator/api/v1alpha1"
+	"github.com/neoaggelos/microk8s-operator/pkg/nodeutil"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NodeVersion is the Kubernetes version of a MicroK8s node.
+type NodeVersion struct {
+	Name         string
+	ControlPlane bool
+	Version      Version
+}
+
+// ClusterVersions returns the Kubernetes versions of all MicroK8s nodes in the cluster.
+// Nodes that do not report a valid version yet are skipped.
+func ClusterVersions(ctx context.Context, c client.Client) ([]NodeVersion, error) {
+	microk8sNodes := &microk8sv1alpha1.MicroK8sNodeList{}
+	if err := c.List(ctx, microk8sNodes); err != nil {
+		return nil, fmt.Errorf("failed to list microk8s nodes: %w", err)
+	}
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	controlPlane := make(map[string]bool, len(nodes.Items))
+	for i := range nodes.Items {
+		controlPlane[nodes.Items[i].Name] = nodeutil.IsControlPlane(&nodes.Items[i])
+	}
+
+	versions := make([]NodeVersion, 0, len(microk8sNodes.Items))
+	for _, node := range microk8sNodes.Items {
+		version, err := ParseVersion(node.Status.Version, node.Status.Channel)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, NodeVersion{
+			Name:         node.Name,
+			ControlPlane: controlPlane[node.Name],
+			Version:      version,
+		})
+	}
+	return versions, nil
+}
+
+// CheckNode checks the version skew of the named node against all other control plane nodes.
+// It is not an error if the node is not found.
+func CheckNode(nodes []NodeVersion, name string) error {
+	var node *NodeVersion
+	controlPlaneVersions := make([]Version, 0, len(nodes))
+	for i := range nodes {
+		switch {
+		case nodes[i].Name == name:
+			node = &nodes[i]
+		case nodes[i].ControlPlane:
+			controlPlaneVersions = append(controlPlaneVersions, nodes[i].Version)
+		}
+	}
+	if node == nil {
+		return nil
+	}
+	return Check(node.Version, node.ControlPlane, controlPlaneVersions)
+}
+
+// CheckAll checks the version skew of all nodes. It returns an error for the first node that violates the policy.
+func CheckAll(nodes []NodeVersion) error {
+	for _, node := range nodes {
+		if err := CheckNode(nodes, node.Name); err != nil {
+			return fmt.Errorf("node %s: %w", node.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Package skew implements the Kubernetes version skew policy for MicroK8s nodes.
+// See https://kubernetes.io/releases/version-skew-policy/ for details.
+package skew
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxControlPlaneSkew is the maximum minor version difference between control plane nodes.
+const MaxControlPlaneSkew = 1
+
+// MaxKubeletSkew returns the maximum number of minor versions a kubelet may be older than the control plane.
+// This is 3 since Kubernetes 1.28, and 2 for older versions.
+func MaxKubeletSkew(controlPlane Version) int {
+	if controlPlane.Major > 1 || controlPlane.Minor >= 28 {
+		return 3
+	}
+	return 2
+}
+
+// Version is a Kubernetes major.minor version.
+type Version struct {
+	Major int
+	Minor int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// ParseVersion parses the Kubernetes version from a MicroK8s snap version (e.g. "v1.25.2").
+// If the version cannot be parsed, the snap channel (e.g. "1.25/stable") is used instead.
+func ParseVersion(version string, channel string) (Version, error) {
+	if v, err := parseMajorMinor(strings.TrimPrefix(version, "v")); err == nil {
+		return v, nil
+	}
+	if v, err := parseMajorMinor(strings.SplitN(channel, "/", 2)[0]); err == nil {
+		return v, nil
+	}
+	return Version{}, fmt.Errorf("could not parse kubernetes version from version %q or channel %q", version, channel)
+}
+
+func parseMajorMinor(s string) (Version, error) {
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) < 2 {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid major version %q: %w", parts[0], err)
+	}
+	minor, err := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid minor version %q: %w", parts[1], err)
+	}
+	return Version{Major: major, Minor: minor}, nil
+}
+
+// Check returns an error if a node running the specified version is not within the supported version skew
+// of the control plane nodes. controlPlane must be true if the node is itself a control plane node.
+func Check(version Version, controlPlane bool, controlPlaneVersions []Version) error {
+	for _, cp := range controlPlaneVersions {
+		if cp.Major != version.Major {
+			return fmt.Errorf("version %s has a different major version than control plane version %s", version, cp)
+		}
+		diff := cp.Minor - version.Minor
+		switch {
+		case controlPlane && (diff > MaxControlPlaneSkew || diff < -MaxControlPlaneSkew):
+			return fmt.Errorf("control plane version %s is more than %d minor version away from control plane version %s", version, MaxControlPlaneSkew, cp)
+		case !controlPlane && diff < 0:
+			return fmt.Errorf("kubelet version %s is newer than control plane version %s", version, cp)
+		case !controlPlane && diff > MaxKubeletSkew(cp):
+			return fmt.Errorf("kubelet version %s is more than %d minor versions older than control plane version %s", version, MaxKubeletSkew(cp), cp)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package skew
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version  string
+		channel  string
+		expected Version
+		err      bool
+	}{
+		{version: "v1.25.2", channel: "1.25/stable", expected: Version{1, 25}},
+		{version: "v1.26.0-rc.1", channel: "latest/edge", expected: Version{1, 26}},
+		{version: "v1.24", expected: Version{1, 24}},
+		{version: "git-abcdef", channel: "1.23/edge", expected: Version{1, 23}},
+		{version: "", channel: "1.27-strict/stable", expected: Version{1, 27}},
+		{version: "", channel: "latest/edge", err: true},
+	} {
+		t.Run(tc.version+"/"+tc.channel, func(t *testing.T) {
+			v, err := ParseVersion(tc.version, tc.channel)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("Expected an error but received none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+			if v != tc.expected {
+				t.Fatalf("Expected version %v but it was %v instead", tc.expected, v)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	controlPlane := []Version{{1, 25}, {1, 26}}
+	for _, tc := range []struct {
+		name         string
+		version      Version
+		controlPlane bool
+		err          bool
+	}{
+		{name: "cp-same", version: Version{1, 25}, controlPlane: true},
+		{name: "cp-too-new", version: Version{1, 27}, controlPlane: true, err: true},
+		{name: "cp-too-old", version: Version{1, 24}, controlPlane: true, err: true},
+		{name: "kubelet-same", version: Version{1, 25}},
+		{name: "kubelet-older", version: Version{1, 24}},
+		{name: "kubelet-too-old", version: Version{1, 23}, err: true},
+		{name: "kubelet-newer", version: Version{1, 26}, err: true},
+		{name: "major", version: Version{2, 25}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Check(tc.version, tc.controlPlane, controlPlane)
+			if tc.err && err == nil {
+				t.Fatalf("Expected an error but received none")
+			}
+			if !tc.err && err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+		})
+	}
+}
+
+func TestCheckKubeletSkew(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		version      Version
+		controlPlane Version
+		err          bool
+	}{
+		{name: "1.27-two-older", version: Version{1, 25}, controlPlane: Version{1, 27}},
+		{name: "1.27-three-older", version: Version{1, 24}, controlPlane: Version{1, 27}, err: true},
+		{name: "1.28-three-older", version: Version{1, 25}, controlPlane: Version{1, 28}},
+		{name: "1.28-four-older", version: Version{1, 24}, controlPlane: Version{1, 28}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Check(tc.version, false, []Version{tc.controlPlane})
+			if tc.err && err == nil {
+				t.Fatalf("Expected an error but received none")
+			}
+			if !tc.err && err != nil {
+				t.Fatalf("Expected no error but received %q", err)
+			}
+		})
+	}
+}